@@ -0,0 +1,754 @@
+package jpegsegs
+
+// Decoding and encoding of arithmetic-coded image data to and from
+// quantized DCT coefficients, following Annexes D and F of the JPEG
+// standard and the implementation in libjpeg.
+
+// qeState is an entry in the probability estimation state machine.
+type qeState struct {
+	qe        int64 // Probability estimate of the LPS.
+	nextLPS   uint8 // Next state after coding an LPS.
+	nextMPS   uint8 // Next state after coding an MPS.
+	switchMPS bool  // Whether the MPS changes after coding an LPS.
+}
+
+// qeTable is the probability estimation state machine from Table D.2,
+// with an additional state giving a fixed probability of 0.5.
+var qeTable = [114]qeState{
+	{0x5A1D, 1, 1, true},
+	{0x2586, 14, 2, false},
+	{0x1114, 16, 3, false},
+	{0x080B, 18, 4, false},
+	{0x03D8, 20, 5, false},
+	{0x01DA, 23, 6, false},
+	{0x00E5, 25, 7, false},
+	{0x006F, 28, 8, false},
+	{0x0036, 30, 9, false},
+	{0x001A, 33, 10, false},
+	{0x000D, 35, 11, false},
+	{0x0006, 9, 12, false},
+	{0x0003, 10, 13, false},
+	{0x0001, 12, 13, false},
+	{0x5A7F, 15, 15, true},
+	{0x3F25, 36, 16, false},
+	{0x2CF2, 38, 17, false},
+	{0x207C, 39, 18, false},
+	{0x17B9, 40, 19, false},
+	{0x1182, 42, 20, false},
+	{0x0CEF, 43, 21, false},
+	{0x09A1, 45, 22, false},
+	{0x072F, 46, 23, false},
+	{0x055C, 48, 24, false},
+	{0x0406, 49, 25, false},
+	{0x0303, 51, 26, false},
+	{0x0240, 52, 27, false},
+	{0x01B1, 54, 28, false},
+	{0x0144, 56, 29, false},
+	{0x00F5, 57, 30, false},
+	{0x00B7, 59, 31, false},
+	{0x008A, 60, 32, false},
+	{0x0068, 62, 33, false},
+	{0x004E, 63, 34, false},
+	{0x003B, 32, 35, false},
+	{0x002C, 33, 9, false},
+	{0x5AE1, 37, 37, true},
+	{0x484C, 64, 38, false},
+	{0x3A0D, 65, 39, false},
+	{0x2EF1, 67, 40, false},
+	{0x261F, 68, 41, false},
+	{0x1F33, 69, 42, false},
+	{0x19A8, 70, 43, false},
+	{0x1518, 72, 44, false},
+	{0x1177, 73, 45, false},
+	{0x0E74, 74, 46, false},
+	{0x0BFB, 75, 47, false},
+	{0x09F8, 77, 48, false},
+	{0x0861, 78, 49, false},
+	{0x0706, 79, 50, false},
+	{0x05CD, 48, 51, false},
+	{0x04DE, 50, 52, false},
+	{0x040F, 50, 53, false},
+	{0x0363, 51, 54, false},
+	{0x02D4, 52, 55, false},
+	{0x025C, 53, 56, false},
+	{0x01F8, 54, 57, false},
+	{0x01A4, 55, 58, false},
+	{0x0160, 56, 59, false},
+	{0x0125, 57, 60, false},
+	{0x00F6, 58, 61, false},
+	{0x00CB, 59, 62, false},
+	{0x00AB, 61, 63, false},
+	{0x008F, 61, 32, false},
+	{0x5B12, 65, 65, true},
+	{0x4D04, 80, 66, false},
+	{0x412C, 81, 67, false},
+	{0x37D8, 82, 68, false},
+	{0x2FE8, 83, 69, false},
+	{0x293C, 84, 70, false},
+	{0x2379, 86, 71, false},
+	{0x1EDF, 87, 72, false},
+	{0x1AA9, 87, 73, false},
+	{0x174E, 72, 74, false},
+	{0x1424, 72, 75, false},
+	{0x119C, 74, 76, false},
+	{0x0F6B, 74, 77, false},
+	{0x0D51, 75, 78, false},
+	{0x0BB6, 77, 79, false},
+	{0x0A40, 77, 48, false},
+	{0x5832, 80, 81, true},
+	{0x4D1C, 88, 82, false},
+	{0x438E, 89, 83, false},
+	{0x3BDD, 90, 84, false},
+	{0x34EE, 91, 85, false},
+	{0x2EAE, 92, 86, false},
+	{0x299A, 93, 87, false},
+	{0x2516, 86, 71, false},
+	{0x5570, 88, 89, true},
+	{0x4CA9, 95, 90, false},
+	{0x44D9, 96, 91, false},
+	{0x3E22, 97, 92, false},
+	{0x3824, 99, 93, false},
+	{0x32B4, 99, 94, false},
+	{0x2E17, 93, 86, false},
+	{0x56A8, 95, 96, true},
+	{0x4F46, 101, 97, false},
+	{0x47E5, 102, 98, false},
+	{0x41CF, 103, 99, false},
+	{0x3C3D, 104, 100, false},
+	{0x375E, 99, 93, false},
+	{0x5231, 105, 102, false},
+	{0x4C0F, 106, 103, false},
+	{0x4639, 107, 104, false},
+	{0x415E, 103, 99, false},
+	{0x5627, 105, 106, true},
+	{0x50E7, 108, 107, false},
+	{0x4B85, 109, 103, false},
+	{0x5597, 110, 109, false},
+	{0x504F, 111, 107, false},
+	{0x5A10, 110, 111, true},
+	{0x5522, 112, 109, false},
+	{0x59EB, 112, 111, true},
+	{0x5A1D, 113, 113, false},
+}
+
+// fixedState is the state used for coding with a fixed probability of
+// 0.5.
+const fixedState = 113
+
+// updateMPS updates a statistics bin after coding an MPS. A bin holds
+// the MPS in bit 7 and a qeTable index in the other bits.
+func updateMPS(st *uint8) {
+	*st = *st&0x80 | qeTable[*st&0x7F].nextMPS
+}
+
+// updateLPS updates a statistics bin after coding an LPS.
+func updateLPS(st *uint8) {
+	state := &qeTable[*st&0x7F]
+	mps := *st & 0x80
+	if state.switchMPS {
+		mps ^= 0x80
+	}
+	*st = mps | state.nextLPS
+}
+
+// arithStats holds the statistics bins and DC predictions for
+// arithmetic coding of a restart interval.
+type arithStats struct {
+	dc      [4][64]uint8  // DC bins for each table destination.
+	ac      [4][256]uint8 // AC bins for each table destination.
+	fixed   uint8         // Bin with a fixed probability.
+	context [4]int        // DC conditioning of each scan component.
+	pred    [4]int32      // DC prediction of each scan component.
+}
+
+// newArithStats returns statistics bins in their initial state.
+func newArithStats() *arithStats {
+	return &arithStats{fixed: fixedState}
+}
+
+// dcContext returns the conditioning context for the next DC
+// difference, section F.1.4.4.1.2, given the magnitude category 'm' and
+// sign of the current one.
+func dcContext(m int32, sign int, cond *ArithmeticTable) int {
+	switch {
+	case m < int32(1)<<cond.L>>1:
+		return 0
+	case m > int32(1)<<cond.U>>1:
+		return 12 + 4*sign
+	}
+	return 4 + 4*sign
+}
+
+// arithDecoder decodes decisions from arithmetic-coded data, without
+// 0xFF escapes. Zeros are supplied after the end of the data.
+type arithDecoder struct {
+	data []byte
+	pos  int
+	c    int64 // Base of the coding interval, followed by unread bits.
+	a    int64 // Size of the coding interval.
+	ct   int   // Number of unread bits in c, negative initially.
+}
+
+// newArithDecoder returns a decoder for the data of a restart
+// interval.
+func newArithDecoder(data []byte) *arithDecoder {
+	return &arithDecoder{data: data, ct: -16}
+}
+
+// decode decodes a decision using a statistics bin, which is updated.
+func (d *arithDecoder) decode(st *uint8) int {
+	// Renormalization and data input, section D.2.6.
+	for d.a < 0x8000 {
+		d.ct--
+		if d.ct < 0 {
+			data := int64(0)
+			if d.pos < len(d.data) {
+				data = int64(d.data[d.pos])
+				d.pos++
+			}
+			d.c = d.c<<8 | data
+			d.ct += 8
+			if d.ct < 0 {
+				// Two bytes are read initially.
+				d.ct++
+				if d.ct == 0 {
+					d.a = 0x8000
+				}
+			}
+		}
+		d.a <<= 1
+	}
+	// Decoding with conditional exchange, sections D.2.4 and D.2.5.
+	q := qeTable[*st&0x7F].qe
+	bit := int(*st >> 7)
+	temp := d.a - q
+	d.a = temp
+	temp <<= uint(d.ct)
+	if d.c >= temp {
+		d.c -= temp
+		if d.a < q {
+			updateMPS(st)
+		} else {
+			updateLPS(st)
+			bit ^= 1
+		}
+		d.a = q
+	} else if d.a < 0x8000 {
+		if d.a < q {
+			updateLPS(st)
+			bit ^= 1
+		} else {
+			updateMPS(st)
+		}
+	}
+	return bit
+}
+
+// decodeBits decodes the bits below the top bit of a value with
+// magnitude category 'm', using bin 'st'.
+func (d *arithDecoder) decodeBits(bins []uint8, st int, m int32, sign int) int32 {
+	v := m
+	for m >>= 1; m != 0; m >>= 1 {
+		if d.decode(&bins[st]) != 0 {
+			v |= m
+		}
+	}
+	v++
+	if sign != 0 {
+		v = -v
+	}
+	return v
+}
+
+// decodeDCDiff decodes a DC difference for scan component 'i',
+// section F.1.4.4.1.
+func (d *arithDecoder) decodeDCDiff(stats *arithStats, i int, dest uint8, cond *ArithmeticTable) (int32, error) {
+	bins := stats.dc[dest&3][:]
+	st := stats.context[i]
+	if d.decode(&bins[st]) == 0 {
+		stats.context[i] = 0
+		return 0, nil
+	}
+	sign := d.decode(&bins[st+1])
+	st += 2 + sign
+	m := int32(d.decode(&bins[st]))
+	if m != 0 {
+		for st = 20; d.decode(&bins[st]) != 0; st++ {
+			if m <<= 1; m == 0x8000 {
+				return 0, newSyntaxError(ErrArithmeticCode)
+			}
+		}
+	}
+	stats.context[i] = dcContext(m, sign, cond)
+	return d.decodeBits(bins, st+14, m, sign), nil
+}
+
+// decodeACValue decodes a nonzero AC coefficient with index 'k',
+// section F.1.4.4.2. 'st' is the bin of its end-of-block decision.
+func (d *arithDecoder) decodeACValue(stats *arithStats, bins []uint8, st, k int, cond *ArithmeticTable) (int32, error) {
+	sign := d.decode(&stats.fixed)
+	st += 2
+	m := int32(d.decode(&bins[st]))
+	if m != 0 && d.decode(&bins[st]) != 0 {
+		m <<= 1
+		st = 217
+		if k <= int(cond.K) {
+			st = 189
+		}
+		for ; d.decode(&bins[st]) != 0; st++ {
+			if m <<= 1; m == 0x8000 {
+				return 0, newSyntaxError(ErrArithmeticCode)
+			}
+		}
+	}
+	return d.decodeBits(bins, st+14, m, sign), nil
+}
+
+// decodeAC decodes the AC coefficients from 'ss' to 'se' of a block,
+// shifted left by 'al', in a sequential scan or the first scan of a
+// progressive spectral band.
+func (d *arithDecoder) decodeAC(stats *arithStats, block *Block, dest uint8, cond *ArithmeticTable, ss, se int, al uint8) error {
+	bins := stats.ac[dest&3][:]
+	for k := ss; k <= se; k++ {
+		st := 3 * (k - 1)
+		if d.decode(&bins[st]) != 0 {
+			break
+		}
+		for d.decode(&bins[st+1]) == 0 {
+			st += 3
+			if k++; k > se {
+				return newSyntaxError(ErrArithmeticCode)
+			}
+		}
+		v, err := d.decodeACValue(stats, bins, st, k, cond)
+		if err != nil {
+			return err
+		}
+		block[ZigZag[k]] = int16(v << al)
+	}
+	return nil
+}
+
+// decodeACRefine decodes a block in a successive approximation
+// refinement scan of a progressive spectral band, section G.1.3.3.
+func (d *arithDecoder) decodeACRefine(stats *arithStats, block *Block, dest uint8, ss, se int, al uint8) error {
+	bins := stats.ac[dest&3][:]
+	p1 := int16(1) << al
+	m1 := int16(-1) << al
+	// End of block in the previous stage.
+	kex := se
+	for kex > 0 && block[ZigZag[kex]] == 0 {
+		kex--
+	}
+	for k := ss; k <= se; k++ {
+		st := 3 * (k - 1)
+		if k > kex && d.decode(&bins[st]) != 0 {
+			break
+		}
+		for {
+			coef := &block[ZigZag[k]]
+			if *coef != 0 {
+				if d.decode(&bins[st+2]) != 0 {
+					if *coef < 0 {
+						*coef += m1
+					} else {
+						*coef += p1
+					}
+				}
+				break
+			}
+			if d.decode(&bins[st+1]) != 0 {
+				if d.decode(&stats.fixed) != 0 {
+					*coef = m1
+				} else {
+					*coef = p1
+				}
+				break
+			}
+			st += 3
+			if k++; k > se {
+				return newSyntaxError(ErrArithmeticCode)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeArithmeticInterval decodes 'count' MCUs starting at MCU 'first'
+// from arithmetic-coded data. The data must start at the beginning of a
+// restart interval.
+func (coeffs *Coefficients) decodeArithmeticInterval(state *scanState, data []byte, first, count int) error {
+	d := newArithDecoder(data)
+	stats := newArithStats()
+	scan := state.header
+	progressive := coeffs.Marker.Process().Progressive
+	ss, se, al := int(scan.Ss), int(scan.Se), scan.Al
+	for mcu := first; mcu < first+count; mcu++ {
+		err := coeffs.mcuBlocks(scan, state.comps, mcu, func(i int, block *Block) error {
+			sc := &scan.Components[i]
+			dcCond := &state.cond[0][sc.Td&3]
+			acCond := &state.cond[1][sc.Ta&3]
+			switch {
+			case !progressive:
+				diff, err := d.decodeDCDiff(stats, i, sc.Td, dcCond)
+				if err != nil {
+					return err
+				}
+				stats.pred[i] += diff
+				block[0] = int16(stats.pred[i])
+				return d.decodeAC(stats, block, sc.Ta, acCond, 1, 63, 0)
+			case ss == 0 && scan.Ah == 0:
+				diff, err := d.decodeDCDiff(stats, i, sc.Td, dcCond)
+				if err != nil {
+					return err
+				}
+				stats.pred[i] += diff
+				block[0] = int16(stats.pred[i] << al)
+			case ss == 0:
+				if d.decode(&stats.fixed) != 0 {
+					block[0] |= 1 << al
+				}
+			case scan.Ah == 0:
+				return d.decodeAC(stats, block, sc.Ta, acCond, ss, se, al)
+			default:
+				return d.decodeACRefine(stats, block, sc.Ta, ss, se, al)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arithEncoder encodes decisions as arithmetic-coded data, without
+// 0xFF escapes.
+type arithEncoder struct {
+	buf    []byte
+	c      int64 // Base of the coding interval.
+	a      int64 // Size of the coding interval.
+	sc     int   // Number of stacked 0xFF bytes, which a carry may change.
+	zc     int   // Number of pending zero bytes, dropped at the end.
+	ct     int   // Number of bits until the next byte is complete.
+	buffer int   // Latest byte not yet output, or -1.
+}
+
+// newArithEncoder returns an encoder for the data of a restart
+// interval.
+func newArithEncoder() *arithEncoder {
+	return &arithEncoder{a: 0x10000, ct: 11, buffer: -1}
+}
+
+// emit outputs a byte, preceded by any pending zero bytes.
+func (e *arithEncoder) emit(b int) {
+	for ; e.zc > 0; e.zc-- {
+		e.buf = append(e.buf, 0)
+	}
+	e.buf = append(e.buf, byte(b))
+}
+
+// carry outputs the buffered byte plus a carry, after which the
+// stacked 0xFF bytes become pending zero bytes.
+func (e *arithEncoder) carry() {
+	if e.buffer >= 0 {
+		e.emit(e.buffer + 1)
+	}
+	e.zc += e.sc
+	e.sc = 0
+}
+
+// release outputs the buffered byte and the stacked 0xFF bytes, which
+// can no longer be changed by a carry.
+func (e *arithEncoder) release() {
+	if e.buffer == 0 {
+		e.zc++
+	} else if e.buffer > 0 {
+		e.emit(e.buffer)
+	}
+	for ; e.sc > 0; e.sc-- {
+		e.emit(0xFF)
+	}
+}
+
+// encode encodes a decision using a statistics bin, which is updated.
+func (e *arithEncoder) encode(st *uint8, bit int) {
+	// Encoding with conditional exchange, sections D.1.4 and D.1.5.
+	q := qeTable[*st&0x7F].qe
+	e.a -= q
+	if bit != int(*st>>7) {
+		if e.a >= q {
+			e.c += e.a
+			e.a = q
+		}
+		updateLPS(st)
+	} else {
+		if e.a >= 0x8000 {
+			return
+		}
+		if e.a < q {
+			e.c += e.a
+			e.a = q
+		}
+		updateMPS(st)
+	}
+	// Renormalization and data output, section D.1.6.
+	for e.a < 0x8000 {
+		e.a <<= 1
+		e.c <<= 1
+		if e.ct--; e.ct == 0 {
+			temp := e.c >> 19
+			switch {
+			case temp > 0xFF:
+				e.carry()
+				e.buffer = int(temp & 0xFF)
+			case temp == 0xFF:
+				e.sc++
+			default:
+				e.release()
+				e.buffer = int(temp)
+			}
+			e.c &= 0x7FFFF
+			e.ct += 8
+		}
+	}
+}
+
+// finish terminates the coded data, section D.1.8, and returns it.
+// Trailing zero bytes are omitted, since the decoder supplies them.
+func (e *arithEncoder) finish() []byte {
+	// Choose the value in the interval with the most trailing zeros.
+	temp := (e.a - 1 + e.c) & 0xFFFF0000
+	if temp < e.c {
+		e.c = temp + 0x8000
+	} else {
+		e.c = temp
+	}
+	e.c <<= uint(e.ct)
+	if e.c&0xF8000000 != 0 {
+		e.carry()
+	} else {
+		e.release()
+	}
+	if e.c&0x7FFF800 != 0 {
+		e.emit(int(e.c>>19) & 0xFF)
+		if e.c&0x7F800 != 0 {
+			e.emit(int(e.c>>11) & 0xFF)
+		}
+	}
+	return e.buf
+}
+
+// encodeBits encodes the bits below the top bit of 'v', which has
+// magnitude category 'm', using bin 'st'.
+func (e *arithEncoder) encodeBits(bins []uint8, st int, m, v int32) {
+	for m >>= 1; m != 0; m >>= 1 {
+		bit := 0
+		if m&v != 0 {
+			bit = 1
+		}
+		e.encode(&bins[st], bit)
+	}
+}
+
+// encodeDCDiff encodes the difference between a DC value and the
+// prediction for scan component 'i', section F.1.4.4.1.
+func (e *arithEncoder) encodeDCDiff(stats *arithStats, i int, dest uint8, cond *ArithmeticTable, dc int32) {
+	bins := stats.dc[dest&3][:]
+	st := stats.context[i]
+	v := dc - stats.pred[i]
+	stats.pred[i] = dc
+	if v == 0 {
+		e.encode(&bins[st], 0)
+		stats.context[i] = 0
+		return
+	}
+	e.encode(&bins[st], 1)
+	sign := 0
+	if v < 0 {
+		sign = 1
+		v = -v
+	}
+	e.encode(&bins[st+1], sign)
+	st += 2 + sign
+	m := int32(0)
+	if v--; v != 0 {
+		e.encode(&bins[st], 1)
+		m = 1
+		st = 20
+		for v2 := v >> 1; v2 != 0; v2 >>= 1 {
+			e.encode(&bins[st], 1)
+			m <<= 1
+			st++
+		}
+	}
+	e.encode(&bins[st], 0)
+	stats.context[i] = dcContext(m, sign, cond)
+	e.encodeBits(bins, st+14, m, v)
+}
+
+// encodeACValue encodes a nonzero AC coefficient with index 'k',
+// section F.1.4.4.2. 'st' is the bin of its end-of-block decision.
+func (e *arithEncoder) encodeACValue(stats *arithStats, bins []uint8, st, k int, cond *ArithmeticTable, v int32) {
+	sign := 0
+	if v < 0 {
+		sign = 1
+		v = -v
+	}
+	e.encode(&stats.fixed, sign)
+	st += 2
+	m := int32(0)
+	if v--; v != 0 {
+		e.encode(&bins[st], 1)
+		m = 1
+		if v2 := v >> 1; v2 != 0 {
+			e.encode(&bins[st], 1)
+			m <<= 1
+			st = 217
+			if k <= int(cond.K) {
+				st = 189
+			}
+			for v2 >>= 1; v2 != 0; v2 >>= 1 {
+				e.encode(&bins[st], 1)
+				m <<= 1
+				st++
+			}
+		}
+	}
+	e.encode(&bins[st], 0)
+	e.encodeBits(bins, st+14, m, v)
+}
+
+// pointTransform returns a coefficient divided by 2^al, rounding
+// towards zero.
+func pointTransform(coef int16, al uint8) int32 {
+	v := int32(coef)
+	if v < 0 {
+		return -(-v >> al)
+	}
+	return v >> al
+}
+
+// encodeAC encodes the AC coefficients from 'ss' to 'se' of a block,
+// divided by 2^al, in a sequential scan or the first scan of a
+// progressive spectral band.
+func (e *arithEncoder) encodeAC(stats *arithStats, block *Block, dest uint8, cond *ArithmeticTable, ss, se int, al uint8) {
+	bins := stats.ac[dest&3][:]
+	eob := se
+	for eob >= ss && pointTransform(block[ZigZag[eob]], al) == 0 {
+		eob--
+	}
+	k := ss
+	for ; k <= eob; k++ {
+		st := 3 * (k - 1)
+		e.encode(&bins[st], 0)
+		v := pointTransform(block[ZigZag[k]], al)
+		for v == 0 {
+			e.encode(&bins[st+1], 0)
+			st += 3
+			k++
+			v = pointTransform(block[ZigZag[k]], al)
+		}
+		e.encode(&bins[st+1], 1)
+		e.encodeACValue(stats, bins, st, k, cond, v)
+	}
+	if k <= se {
+		e.encode(&bins[3*(k-1)], 1)
+	}
+}
+
+// encodeACRefine encodes a block in a successive approximation
+// refinement scan of a progressive spectral band, section G.1.3.3.
+func (e *arithEncoder) encodeACRefine(stats *arithStats, block *Block, dest uint8, ss, se int, ah, al uint8) {
+	bins := stats.ac[dest&3][:]
+	abs := func(k int, shift uint8) int32 {
+		v := pointTransform(block[ZigZag[k]], shift)
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	eob := se
+	for eob >= ss && abs(eob, al) == 0 {
+		eob--
+	}
+	// End of block in the previous stage.
+	kex := eob
+	for kex > 0 && abs(kex, ah) == 0 {
+		kex--
+	}
+	k := ss
+	for ; k <= eob; k++ {
+		st := 3 * (k - 1)
+		if k > kex {
+			e.encode(&bins[st], 0)
+		}
+		for {
+			v := abs(k, al)
+			if v > 1 {
+				e.encode(&bins[st+2], int(v&1))
+				break
+			}
+			if v == 1 {
+				e.encode(&bins[st+1], 1)
+				sign := 0
+				if block[ZigZag[k]] < 0 {
+					sign = 1
+				}
+				e.encode(&stats.fixed, sign)
+				break
+			}
+			e.encode(&bins[st+1], 0)
+			st += 3
+			k++
+		}
+	}
+	if k <= se {
+		e.encode(&bins[3*(k-1)], 1)
+	}
+}
+
+// encodeArithmeticScan encodes the blocks of a scan with the default
+// conditioning tables, returning the arithmetic-coded data of each
+// restart interval, without 0xFF escapes.
+func (coeffs *Coefficients) encodeArithmeticScan(scan *ScanHeader, progressive bool) ([][]byte, error) {
+	comps, err := coeffs.Frame.scanComponents(scan)
+	if err != nil {
+		return nil, err
+	}
+	cond := arithmeticConditioning(nil)
+	ss, se, al := int(scan.Ss), int(scan.Se), scan.Al
+	mcus := coeffs.scanMCUs(comps)
+	interval := coeffs.RestartInterval
+	if interval == 0 {
+		interval = mcus
+	}
+	var intervals [][]byte
+	for first := 0; first < mcus; first += interval {
+		e := newArithEncoder()
+		stats := newArithStats()
+		for mcu := first; mcu < first+interval && mcu < mcus; mcu++ {
+			coeffs.mcuBlocks(scan, comps, mcu, func(i int, block *Block) error {
+				sc := &scan.Components[i]
+				dcCond := &cond[0][sc.Td&3]
+				acCond := &cond[1][sc.Ta&3]
+				switch {
+				case !progressive:
+					e.encodeDCDiff(stats, i, sc.Td, dcCond, int32(block[0]))
+					e.encodeAC(stats, block, sc.Ta, acCond, 1, 63, 0)
+				case ss == 0 && scan.Ah == 0:
+					e.encodeDCDiff(stats, i, sc.Td, dcCond, int32(block[0])>>al)
+				case ss == 0:
+					e.encode(&stats.fixed, int(block[0]>>al)&1)
+				case scan.Ah == 0:
+					e.encodeAC(stats, block, sc.Ta, acCond, ss, se, al)
+				default:
+					e.encodeACRefine(stats, block, sc.Ta, ss, se, scan.Ah, al)
+				}
+				return nil
+			})
+		}
+		intervals = append(intervals, e.finish())
+	}
+	return intervals, nil
+}
@@ -0,0 +1,69 @@
+package jpegsegs
+
+import (
+	"testing"
+)
+
+func TestArithmeticLibjpeg(t *testing.T) {
+	// Written by libjpeg from the coefficients of huffman.jpg, with
+	// restart intervals of 5 and 3 MCUs.
+	coeffs, _ := readCoefficients(t, readFile(t, "huffman.jpg"))
+	tests := []struct {
+		name    string
+		marker  Marker
+		restart int
+	}{
+		{"arithmetic.jpg", SOF9, 5},
+		{"arithmetic_progressive.jpg", SOF10, 3},
+	}
+	for _, test := range tests {
+		data := readFile(t, test.name)
+		for _, workers := range []int{0, 4} {
+			dec, err := decodeWorkers(data, workers)
+			if err != nil {
+				t.Fatalf("%s, %d workers: %v", test.name, workers, err)
+			}
+			arith := dec.Coefficients
+			if arith.Marker != test.marker || arith.RestartInterval != test.restart {
+				t.Errorf("%s: %s frame with restart interval %d", test.name, arith.Marker.Name(), arith.RestartInterval)
+			}
+			sameCoefficients(t, coeffs, arith)
+		}
+	}
+}
+
+func TestArithmeticRoundTrip(t *testing.T) {
+	tests := []struct {
+		gray        bool
+		progressive bool
+		restart     int
+		marker      Marker
+	}{
+		{false, false, 0, SOF9},
+		{false, false, 3, SOF9},
+		{false, true, 0, SOF10},
+		{false, true, 3, SOF10},
+		{true, false, 0, SOF9},
+		{true, true, 2, SOF10},
+	}
+	for _, test := range tests {
+		data := makeJPEG(t, 203, 117, test.gray)
+		coeffs, segments := readCoefficients(t, data)
+		coeffs.RestartInterval = test.restart
+		arith := writeCoefficients(t, coeffs, segments, &EncodeOptions{Arithmetic: true, Progressive: test.progressive})
+		for _, workers := range []int{0, 4} {
+			dec, err := decodeWorkers(arith, workers)
+			if err != nil {
+				t.Fatalf("%+v, %d workers: %v", test, workers, err)
+			}
+			if dec.Coefficients.Marker != test.marker {
+				t.Errorf("%+v: %s frame", test, dec.Coefficients.Marker.Name())
+			}
+			sameCoefficients(t, coeffs, dec.Coefficients)
+		}
+		// Transcoding to Huffman coding reproduces the pixels.
+		decoded, _ := readCoefficients(t, arith)
+		decoded.RestartInterval = 0
+		samePixels(t, data, writeCoefficients(t, decoded, segments, nil))
+	}
+}
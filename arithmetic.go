@@ -0,0 +1,99 @@
+package jpegsegs
+
+// ArithmeticTable is an arithmetic coding conditioning table from a DAC
+// segment. Tables that aren't defined take their default values, as
+// returned by DefaultArithmeticTable.
+type ArithmeticTable struct {
+	Class uint8 // 0 for DC or lossless tables, 1 for AC tables.
+	Dest  uint8 // Destination identifier, 0-3.
+	L, U  uint8 // DC tables: lower and upper conditioning bounds, 0-15.
+	K     uint8 // AC tables: spectral conditioning threshold, 1-63.
+}
+
+// DefaultArithmeticTable returns the conditioning table that applies
+// to a class and destination if no DAC segment defines it.
+func DefaultArithmeticTable(class, dest uint8) ArithmeticTable {
+	return ArithmeticTable{Class: class, Dest: dest, L: 0, U: 1, K: 5}
+}
+
+// GetArithmeticTables decodes the conditioning tables in a DAC
+// segment. The tables are validated with ArithmeticTable.Validate.
+func GetArithmeticTables(buf []byte) ([]ArithmeticTable, error) {
+	if len(buf) == 0 || len(buf)%2 != 0 {
+		return nil, &SyntaxError{-1, DAC, ErrArithmeticTable}
+	}
+	tables := make([]ArithmeticTable, len(buf)/2)
+	for i := range tables {
+		table := &tables[i]
+		table.Class = buf[2*i] >> 4
+		table.Dest = buf[2*i] & 0xF
+		value := buf[2*i+1]
+		if table.Class == 0 {
+			table.L = value & 0xF
+			table.U = value >> 4
+		} else {
+			table.K = value
+		}
+		if err := table.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// MakeArithmeticSegment encodes conditioning tables into a newly
+// allocated slice, which can be used as a DAC segment.
+func MakeArithmeticSegment(tables []ArithmeticTable) []byte {
+	buf := make([]byte, 0, 2*len(tables))
+	for _, table := range tables {
+		buf = append(buf, table.Class<<4|table.Dest&0xF)
+		if table.Class == 0 {
+			buf = append(buf, table.U<<4|table.L&0xF)
+		} else {
+			buf = append(buf, table.K)
+		}
+	}
+	return buf
+}
+
+// Validate checks that a conditioning table is valid: the class and
+// destination are in range, L is not greater than U for a DC table,
+// and K is from 1 to 63 for an AC table.
+func (table *ArithmeticTable) Validate() error {
+	valid := table.Class <= 1 && table.Dest <= 3
+	if table.Class == 0 {
+		valid = valid && table.L <= table.U && table.U <= 15
+	} else {
+		valid = valid && table.K >= 1 && table.K <= 63
+	}
+	if !valid {
+		return &SyntaxError{-1, DAC, ErrArithmeticTable}
+	}
+	return nil
+}
+
+// setArithmeticTable defines or redefines a conditioning table.
+func setArithmeticTable(tables []ArithmeticTable, table ArithmeticTable) []ArithmeticTable {
+	for i := range tables {
+		if tables[i].Class == table.Class && tables[i].Dest == table.Dest {
+			tables[i] = table
+			return tables
+		}
+	}
+	return append(tables, table)
+}
+
+// arithmeticConditioning returns the conditioning tables in effect for
+// each class and destination, given the tables that have been defined.
+func arithmeticConditioning(tables []ArithmeticTable) [2][4]ArithmeticTable {
+	var cond [2][4]ArithmeticTable
+	for class := range cond {
+		for dest := range cond[class] {
+			cond[class][dest] = DefaultArithmeticTable(uint8(class), uint8(dest))
+		}
+	}
+	for _, table := range tables {
+		cond[table.Class&1][table.Dest&3] = table
+	}
+	return cond
+}
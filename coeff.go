@@ -0,0 +1,477 @@
+package jpegsegs
+
+// Decoding and encoding of Huffman-coded image data to and from
+// quantized DCT coefficients. Arithmetic coding is in arithcode.go.
+
+// Block holds the quantized DCT coefficients of an 8x8 block, in
+// natural (row-major) order.
+type Block [64]int16
+
+// ComponentCoefficients holds the coefficient blocks of an image
+// component.
+type ComponentCoefficients struct {
+	FrameComponent
+	Width  int     // Number of blocks per row covering the component's samples.
+	Height int     // Number of block rows covering the component's samples.
+	Stride int     // Number of blocks per row in Blocks, padded to whole MCUs.
+	Rows   int     // Number of block rows in Blocks, padded to whole MCUs.
+	Blocks []Block // Stride * Rows blocks in row-major order.
+}
+
+// Block returns the block at column x, row y.
+func (comp *ComponentCoefficients) Block(x, y int) *Block {
+	return &comp.Blocks[y*comp.Stride+x]
+}
+
+// Coefficients holds the quantized DCT coefficients of a frame,
+// together with the tables required to encode them.
+type Coefficients struct {
+	Marker          Marker // SOFn marker of the frame.
+	Frame           FrameHeader
+	Components      []ComponentCoefficients // In the same order as Frame.Components.
+	MCUsWide        int                     // Number of MCUs per row in interleaved scans.
+	MCUsHigh        int                     // Number of MCU rows in interleaved scans.
+	QuantTables     []QuantTable            // Quantization tables, one for each destination used.
+	HuffmanTables   []HuffmanTable          // Huffman tables in effect at the first scan.
+	RestartInterval int                     // Number of MCUs per restart interval, or 0.
+	Scans           []ScanHeader            // Scan headers, in the order decoded.
+}
+
+// NewCoefficients allocates zeroed coefficient blocks for a frame.
+func NewCoefficients(marker Marker, frame *FrameHeader) *Coefficients {
+	coeffs := &Coefficients{Marker: marker, Frame: *frame}
+	coeffs.Frame.Components = append([]FrameComponent(nil), frame.Components...)
+	hmax, vmax := frame.MaxSampling()
+	width, height := int(frame.Width), int(frame.Height)
+	coeffs.MCUsWide = (width + 8*hmax - 1) / (8 * hmax)
+	coeffs.MCUsHigh = (height + 8*vmax - 1) / (8 * vmax)
+	coeffs.Components = make([]ComponentCoefficients, len(frame.Components))
+	for i, fc := range frame.Components {
+		comp := &coeffs.Components[i]
+		comp.FrameComponent = fc
+		comp.Width = ((width*int(fc.H)+hmax-1)/hmax + 7) / 8
+		comp.Height = ((height*int(fc.V)+vmax-1)/vmax + 7) / 8
+		comp.Stride = coeffs.MCUsWide * int(fc.H)
+		comp.Rows = coeffs.MCUsHigh * int(fc.V)
+		comp.Blocks = make([]Block, comp.Stride*comp.Rows)
+	}
+	return coeffs
+}
+
+// QuantTable returns the quantization table with the given
+// destination, or nil if not defined.
+func (coeffs *Coefficients) QuantTable(dest uint8) *QuantTable {
+	for i := range coeffs.QuantTables {
+		if coeffs.QuantTables[i].Dest == dest {
+			return &coeffs.QuantTables[i]
+		}
+	}
+	return nil
+}
+
+// scanState holds the state of the scan being decoded or encoded.
+type scanState struct {
+	header  *ScanHeader
+	comps   []int // Index of each scan component in the frame.
+	dc      []*huffDecoder
+	ac      []*huffDecoder
+	cond    [2][4]ArithmeticTable // Arithmetic conditioning tables.
+	samples *Samples              // Frame being decoded, if lossless.
+	mcus    int                   // Total number of MCUs in the scan.
+	next    int                   // Index of the next MCU to be decoded.
+	resets  int                   // Number of RST markers seen.
+}
+
+// blocks calls 'f' for each block in MCU 'mcu' of a scan, with the
+// index of the scan component.
+func (coeffs *Coefficients) mcuBlocks(scan *ScanHeader, comps []int, mcu int, f func(int, *Block) error) error {
+	if len(comps) == 1 {
+		comp := &coeffs.Components[comps[0]]
+		return f(0, comp.Block(mcu%comp.Width, mcu/comp.Width))
+	}
+	mcuX := mcu % coeffs.MCUsWide
+	mcuY := mcu / coeffs.MCUsWide
+	for i, ci := range comps {
+		comp := &coeffs.Components[ci]
+		for v := 0; v < int(comp.V); v++ {
+			for h := 0; h < int(comp.H); h++ {
+				if err := f(i, comp.Block(mcuX*int(comp.H)+h, mcuY*int(comp.V)+v)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanMCUs returns the number of MCUs in a scan of the given frame
+// components.
+func (coeffs *Coefficients) scanMCUs(comps []int) int {
+	if len(comps) == 1 {
+		comp := &coeffs.Components[comps[0]]
+		return comp.Width * comp.Height
+	}
+	return coeffs.MCUsWide * coeffs.MCUsHigh
+}
+
+// decodeInterval decodes 'count' MCUs starting at MCU 'first' from
+// Huffman-coded or arithmetic-coded data. The data must start at the
+// beginning of a restart interval. Returns the number of whole bytes
+// of data left over, or a negative number if the data was too short.
+// The end of arithmetic-coded data isn't checked, so 0 is returned.
+func (coeffs *Coefficients) decodeInterval(state *scanState, data []byte, first, count int) (int, error) {
+	if coeffs.Marker.Process().Arithmetic {
+		return 0, coeffs.decodeArithmeticInterval(state, data, first, count)
+	}
+	br := bitReader{data: data}
+	scan := state.header
+	var pred [4]int32
+	eobrun := int32(0)
+	progressive := coeffs.Marker.Process().Progressive
+	al := uint(scan.Al)
+	for mcu := first; mcu < first+count; mcu++ {
+		err := coeffs.mcuBlocks(scan, state.comps, mcu, func(i int, block *Block) error {
+			switch {
+			case !progressive:
+				return decodeSequential(&br, block, &pred[i], state.dc[i], state.ac[i])
+			case scan.Ss == 0 && scan.Ah == 0:
+				t, err := br.decode(state.dc[i])
+				if err != nil {
+					return err
+				}
+				pred[i] += br.receiveExtend(uint(t))
+				block[0] = int16(pred[i] << al)
+			case scan.Ss == 0:
+				if br.bits(1) != 0 {
+					block[0] |= 1 << al
+				}
+			case scan.Ah == 0:
+				return decodeACFirst(&br, block, &eobrun, state.ac[i], scan)
+			default:
+				return decodeACRefine(&br, block, &eobrun, state.ac[i], scan)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return br.unused(), nil
+}
+
+// decodeSequential decodes a block in a sequential scan.
+func decodeSequential(br *bitReader, block *Block, pred *int32, dc, ac *huffDecoder) error {
+	t, err := br.decode(dc)
+	if err != nil {
+		return err
+	}
+	*pred += br.receiveExtend(uint(t))
+	block[0] = int16(*pred)
+	for k := 1; k < 64; k++ {
+		rs, err := br.decode(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r != 15 {
+				break
+			}
+			k += 15
+			continue
+		}
+		k += r
+		if k > 63 {
+			return newSyntaxError(ErrHuffmanCode)
+		}
+		block[ZigZag[k]] = int16(br.receiveExtend(s))
+	}
+	return nil
+}
+
+// decodeACFirst decodes a block in the first scan of a progressive
+// spectral band.
+func decodeACFirst(br *bitReader, block *Block, eobrun *int32, ac *huffDecoder, scan *ScanHeader) error {
+	if *eobrun > 0 {
+		*eobrun--
+		return nil
+	}
+	for k := int(scan.Ss); k <= int(scan.Se); k++ {
+		rs, err := br.decode(ac)
+		if err != nil {
+			return err
+		}
+		r, s := uint(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r != 15 {
+				*eobrun = 1<<r - 1 + br.bits(r)
+				break
+			}
+			k += 15
+			continue
+		}
+		k += int(r)
+		if k > 63 {
+			return newSyntaxError(ErrHuffmanCode)
+		}
+		block[ZigZag[k]] = int16(br.receiveExtend(s) << scan.Al)
+	}
+	return nil
+}
+
+// decodeACRefine decodes a block in a successive approximation
+// refinement scan of a progressive spectral band.
+func decodeACRefine(br *bitReader, block *Block, eobrun *int32, ac *huffDecoder, scan *ScanHeader) error {
+	p1 := int16(1) << scan.Al
+	m1 := int16(-1) << scan.Al
+	k := int(scan.Ss)
+	se := int(scan.Se)
+	// refine appends a correction bit to a coefficient that's
+	// already nonzero.
+	refine := func(coef *int16) {
+		if br.bits(1) != 0 && *coef&p1 == 0 {
+			if *coef >= 0 {
+				*coef += p1
+			} else {
+				*coef += m1
+			}
+		}
+	}
+	if *eobrun == 0 {
+		for ; k <= se; k++ {
+			rs, err := br.decode(ac)
+			if err != nil {
+				return err
+			}
+			r, s := int(rs>>4), int16(rs&15)
+			if s != 0 {
+				if br.bits(1) != 0 {
+					s = p1
+				} else {
+					s = m1
+				}
+			} else if r != 15 {
+				*eobrun = 1<<uint(r) + br.bits(uint(r))
+				break
+			}
+			// Skip r zero coefficients, refining the nonzero
+			// coefficients passed over.
+			for ; k <= se; k++ {
+				coef := &block[ZigZag[k]]
+				if *coef != 0 {
+					refine(coef)
+				} else {
+					r--
+					if r < 0 {
+						break
+					}
+				}
+			}
+			if s != 0 {
+				if k > 63 {
+					return newSyntaxError(ErrHuffmanCode)
+				}
+				block[ZigZag[k]] = s
+			}
+		}
+	}
+	if *eobrun > 0 {
+		for ; k <= se; k++ {
+			coef := &block[ZigZag[k]]
+			if *coef != 0 {
+				refine(coef)
+			}
+		}
+		*eobrun--
+	}
+	return nil
+}
+
+// encodeSequential encodes a block in a sequential scan.
+func encodeSequential(bw *bitWriter, block *Block, pred *int32, dc, ac *huffEncoder) error {
+	diff := int32(block[0]) - *pred
+	*pred = int32(block[0])
+	n := category(diff)
+	if err := bw.encode(dc, uint8(n)); err != nil {
+		return err
+	}
+	bw.writeValue(diff, n)
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := int32(block[ZigZag[k]])
+		if v == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			if err := bw.encode(ac, 0xF0); err != nil {
+				return err
+			}
+		}
+		n := category(v)
+		if err := bw.encode(ac, uint8(run<<4)|uint8(n)); err != nil {
+			return err
+		}
+		bw.writeValue(v, n)
+		run = 0
+	}
+	if run > 0 {
+		return bw.encode(ac, 0)
+	}
+	return nil
+}
+
+// progressiveEncoder holds the state of an AC scan of a progressive
+// image being encoded, for one restart interval.
+type progressiveEncoder struct {
+	bw     *bitWriter
+	ac     *huffEncoder
+	eobrun int
+	bits   []uint8 // Correction bits deferred until the end of the EOB run.
+}
+
+// flushEOBRun writes any pending EOB run, followed by the correction
+// bits of the blocks in the run.
+func (pe *progressiveEncoder) flushEOBRun() error {
+	if pe.eobrun == 0 {
+		return nil
+	}
+	n := category(int32(pe.eobrun)) - 1
+	if err := pe.bw.encode(pe.ac, uint8(n<<4)); err != nil {
+		return err
+	}
+	pe.bw.write(uint32(pe.eobrun), n)
+	pe.eobrun = 0
+	for _, bit := range pe.bits {
+		pe.bw.write(uint32(bit), 1)
+	}
+	pe.bits = pe.bits[:0]
+	return nil
+}
+
+// endBlock adds a block to the EOB run, with its correction bits.
+func (pe *progressiveEncoder) endBlock(bits []uint8) error {
+	pe.eobrun++
+	pe.bits = append(pe.bits, bits...)
+	if pe.eobrun == 0x7FFF {
+		return pe.flushEOBRun()
+	}
+	return nil
+}
+
+// encodeDCFirst encodes a block in the first scan of the DC
+// coefficients of a progressive image.
+func encodeDCFirst(bw *bitWriter, block *Block, pred *int32, dc *huffEncoder, scan *ScanHeader) error {
+	v := int32(block[0]) >> scan.Al
+	diff := v - *pred
+	*pred = v
+	n := category(diff)
+	if err := bw.encode(dc, uint8(n)); err != nil {
+		return err
+	}
+	bw.writeValue(diff, n)
+	return nil
+}
+
+// encodeACFirst encodes a block in the first scan of a progressive
+// spectral band.
+func (pe *progressiveEncoder) encodeACFirst(block *Block, scan *ScanHeader) error {
+	run := 0
+	for k := int(scan.Ss); k <= int(scan.Se); k++ {
+		// Point transform, rounding towards zero.
+		v := int32(block[ZigZag[k]])
+		if v < 0 {
+			v = -(-v >> scan.Al)
+		} else {
+			v >>= scan.Al
+		}
+		if v == 0 {
+			run++
+			continue
+		}
+		if err := pe.flushEOBRun(); err != nil {
+			return err
+		}
+		for ; run > 15; run -= 16 {
+			if err := pe.bw.encode(pe.ac, 0xF0); err != nil {
+				return err
+			}
+		}
+		n := category(v)
+		if err := pe.bw.encode(pe.ac, uint8(run<<4)|uint8(n)); err != nil {
+			return err
+		}
+		pe.bw.writeValue(v, n)
+		run = 0
+	}
+	if run > 0 {
+		return pe.endBlock(nil)
+	}
+	return nil
+}
+
+// encodeACRefine encodes a block in a successive approximation
+// refinement scan of a progressive spectral band.
+func (pe *progressiveEncoder) encodeACRefine(block *Block, scan *ScanHeader) error {
+	var abs [64]int32
+	// Index of the last coefficient that becomes nonzero in this
+	// scan.
+	eob := 0
+	for k := int(scan.Ss); k <= int(scan.Se); k++ {
+		v := int32(block[ZigZag[k]])
+		if v < 0 {
+			v = -v
+		}
+		abs[k] = v >> scan.Al
+		if abs[k] == 1 {
+			eob = k
+		}
+	}
+	run := 0
+	var bits []uint8 // Correction bits for coefficients already nonzero.
+	writeBits := func() {
+		for _, bit := range bits {
+			pe.bw.write(uint32(bit), 1)
+		}
+		bits = bits[:0]
+	}
+	for k := int(scan.Ss); k <= int(scan.Se); k++ {
+		if abs[k] == 0 {
+			run++
+			continue
+		}
+		// Runs of zeros after the last new nonzero coefficient
+		// can be included in an EOB instead.
+		for run > 15 && k <= eob {
+			if err := pe.flushEOBRun(); err != nil {
+				return err
+			}
+			if err := pe.bw.encode(pe.ac, 0xF0); err != nil {
+				return err
+			}
+			run -= 16
+			writeBits()
+		}
+		if abs[k] > 1 {
+			bits = append(bits, uint8(abs[k]&1))
+			continue
+		}
+		if err := pe.flushEOBRun(); err != nil {
+			return err
+		}
+		if err := pe.bw.encode(pe.ac, uint8(run<<4)|1); err != nil {
+			return err
+		}
+		sign := uint32(0)
+		if block[ZigZag[k]] > 0 {
+			sign = 1
+		}
+		pe.bw.write(sign, 1)
+		writeBits()
+		run = 0
+	}
+	if run > 0 || len(bits) > 0 {
+		return pe.endBlock(bits)
+	}
+	return nil
+}
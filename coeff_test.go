@@ -0,0 +1,112 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+)
+
+// readCoefficients decodes the coefficients of an image.
+func readCoefficients(t testing.TB, data []byte) (*Coefficients, []Segment) {
+	t.Helper()
+	scanner, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coeffs, segments, err := ReadCoefficients(scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coeffs, segments
+}
+
+// writeCoefficients encodes coefficients into a new image.
+func writeCoefficients(t testing.TB, coeffs *Coefficients, segments []Segment, options *EncodeOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	dumper, err := NewDumper(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCoefficients(dumper, coeffs, segments, options); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sameCoefficients checks that two frames have the same coefficients
+// in the blocks that cover the image.
+func sameCoefficients(t testing.TB, a, b *Coefficients) {
+	t.Helper()
+	if len(a.Components) != len(b.Components) {
+		t.Fatalf("%d components, expected %d", len(b.Components), len(a.Components))
+	}
+	for i := range a.Components {
+		ca, cb := &a.Components[i], &b.Components[i]
+		if ca.Width != cb.Width || ca.Height != cb.Height {
+			t.Fatalf("component %d has %dx%d blocks, expected %dx%d", i, cb.Width, cb.Height, ca.Width, ca.Height)
+		}
+		for y := 0; y < ca.Height; y++ {
+			for x := 0; x < ca.Width; x++ {
+				if *ca.Block(x, y) != *cb.Block(x, y) {
+					t.Fatalf("component %d, block %d,%d differs", i, x, y)
+				}
+			}
+		}
+	}
+}
+
+// samePixels checks that two images decode to the same pixels with
+// image/jpeg.
+func samePixels(t testing.TB, a, b []byte) {
+	t.Helper()
+	imgA, err := jpeg.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgB, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := imgA.Bounds()
+	if imgB.Bounds() != bounds {
+		t.Fatalf("bounds %v, expected %v", imgB.Bounds(), bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if imgA.At(x, y) != imgB.At(x, y) {
+				t.Fatalf("pixel %d,%d differs", x, y)
+			}
+		}
+	}
+}
+
+func TestCoefficientsRoundTrip(t *testing.T) {
+	tests := []struct {
+		width, height int
+		gray          bool
+	}{
+		{1, 1, false},
+		{17, 9, false},
+		{64, 48, false},
+		{301, 203, false},
+		{1, 1, true},
+		{77, 53, true},
+	}
+	for _, test := range tests {
+		data := makeJPEG(t, test.width, test.height, test.gray)
+		coeffs, segments := readCoefficients(t, data)
+		if coeffs.Marker != SOF0 || int(coeffs.Frame.Width) != test.width || int(coeffs.Frame.Height) != test.height {
+			t.Fatalf("%dx%d: decoded %s frame %dx%d", test.width, test.height, coeffs.Marker.Name(), coeffs.Frame.Width, coeffs.Frame.Height)
+		}
+		// With the same tables, the image data is reproduced
+		// exactly.
+		out := writeCoefficients(t, coeffs, segments, &EncodeOptions{HuffmanTables: coeffs.HuffmanTables})
+		if !bytes.Equal(out, data) {
+			t.Errorf("%dx%d gray %v: reencoded image differs", test.width, test.height, test.gray)
+		}
+		again, _ := readCoefficients(t, out)
+		sameCoefficients(t, coeffs, again)
+		samePixels(t, data, out)
+	}
+}
@@ -0,0 +1,46 @@
+package jpegsegs
+
+import (
+	"image"
+)
+
+// Crop returns a copy of the coefficients cropped to a rectangle in
+// pixel coordinates. Since blocks can't be split, the top left corner
+// of the rectangle is moved up and left to the nearest iMCU boundary,
+// which is a multiple of 8 pixels times the maximum sampling factor.
+// The rectangle is also clipped to the image. The rectangle actually
+// used is returned with the coefficients. A restart interval that's a
+// whole number of MCU rows is adjusted to the new width.
+func (coeffs *Coefficients) Crop(rect image.Rectangle) (*Coefficients, image.Rectangle, error) {
+	rect = rect.Intersect(image.Rect(0, 0, int(coeffs.Frame.Width), int(coeffs.Frame.Height)))
+	if rect.Empty() {
+		return nil, rect, ErrEmptyCrop
+	}
+	hmax, vmax := coeffs.Frame.MaxSampling()
+	rect.Min.X -= rect.Min.X % (8 * hmax)
+	rect.Min.Y -= rect.Min.Y % (8 * vmax)
+	frame := coeffs.Frame
+	frame.Components = append([]FrameComponent(nil), coeffs.Frame.Components...)
+	frame.Width = uint16(rect.Dx())
+	frame.Height = uint16(rect.Dy())
+	out := NewCoefficients(coeffs.Marker, &frame)
+	out.QuantTables = append([]QuantTable(nil), coeffs.QuantTables...)
+	out.HuffmanTables = coeffs.HuffmanTables
+	out.RestartInterval = coeffs.RestartInterval
+	out.Scans = coeffs.Scans
+	if coeffs.RestartInterval%coeffs.MCUsWide == 0 {
+		out.RestartInterval = coeffs.RestartInterval / coeffs.MCUsWide * out.MCUsWide
+	}
+	for i := range out.Components {
+		dst := &out.Components[i]
+		src := &coeffs.Components[i]
+		offsetX := rect.Min.X / (8 * hmax) * int(src.H)
+		offsetY := rect.Min.Y / (8 * vmax) * int(src.V)
+		for y := 0; y < dst.Rows && y+offsetY < src.Rows; y++ {
+			for x := 0; x < dst.Stride && x+offsetX < src.Stride; x++ {
+				*dst.Block(x, y) = *src.Block(x+offsetX, y+offsetY)
+			}
+		}
+	}
+	return out, rect, nil
+}
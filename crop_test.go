@@ -0,0 +1,52 @@
+package jpegsegs
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCrop(t *testing.T) {
+	tests := []struct {
+		rect     image.Rectangle
+		expected image.Rectangle // Rectangle actually used.
+	}{
+		{image.Rect(20, 17, 60, 40), image.Rect(16, 16, 60, 40)},
+		{image.Rect(0, 0, 77, 53), image.Rect(0, 0, 77, 53)},
+		{image.Rect(33, 40, 200, 200), image.Rect(32, 32, 77, 53)},
+		{image.Rect(-5, -5, 2, 2), image.Rect(0, 0, 2, 2)},
+	}
+	data := makeJPEG(t, 77, 53, false)
+	src, srcStride, _ := luma(t, data)
+	coeffs, segments := readCoefficients(t, data)
+	coeffs.RestartInterval = coeffs.MCUsWide * 2
+	for _, test := range tests {
+		out, rect, err := coeffs.Crop(test.rect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rect != test.expected {
+			t.Errorf("crop %v: used %v, expected %v", test.rect, rect, test.expected)
+			continue
+		}
+		if out.RestartInterval != out.MCUsWide*2 {
+			t.Errorf("crop %v: restart interval %d, expected %d", test.rect, out.RestartInterval, out.MCUsWide*2)
+		}
+		dst, dstStride, bounds := luma(t, writeCoefficients(t, out, segments, nil))
+		if bounds.Dx() != rect.Dx() || bounds.Dy() != rect.Dy() {
+			t.Fatalf("crop %v: decoded %v", test.rect, bounds)
+		}
+		// The blocks are unchanged, so the luma matches except for
+		// rounding in the inverse DCT.
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				diff := int(dst[y*dstStride+x]) - int(src[(y+rect.Min.Y)*srcStride+x+rect.Min.X])
+				if diff < -2 || diff > 2 {
+					t.Fatalf("crop %v: luma at %d,%d differs by %d", test.rect, x, y, diff)
+				}
+			}
+		}
+	}
+	if _, _, err := coeffs.Crop(image.Rect(100, 100, 200, 200)); err != ErrEmptyCrop {
+		t.Errorf("crop outside image: got %v, expected ErrEmptyCrop", err)
+	}
+}
@@ -0,0 +1,368 @@
+package jpegsegs
+
+import (
+	"sync"
+)
+
+// Decoder decodes the entropy-coded data of a JPEG image into DCT
+// coefficients. Markers and segments, as returned by Scanner.Scan, are
+// passed to it in turn via Process, or read from a scanner by Decode.
+type Decoder struct {
+	// Coefficients holds the decoded coefficients, once a DCT frame
+	// header has been processed.
+	Coefficients *Coefficients
+	// Samples holds the decoded samples of each lossless frame, in
+	// the order processed.
+	Samples []*Samples
+	// Hierarchy holds the DHP segment of a hierarchical image, which
+	// gives the dimensions of the final image, or nil if none.
+	Hierarchy *FrameHeader
+	// Segments holds copies of segments that aren't used for
+	// decoding, such as APPn and COM segments.
+	Segments []Segment
+	// Done is set when the EOI marker has been processed.
+	Done bool
+	// CheckRestarts enables checking that RST markers are numbered
+	// in sequence and occur after every restart interval.
+	CheckRestarts bool
+	// Workers is the maximum number of goroutines used to decode
+	// restart intervals concurrently. If it's less than 2, or the
+	// image has no restart intervals, each interval is decoded
+	// before Process returns. Otherwise the decoding of a scan is
+	// completed when the marker following it is processed.
+	Workers int
+
+	quant           []QuantTable
+	huffman         []HuffmanTable
+	arithmetic      []ArithmeticTable
+	dc              [4]*huffDecoder
+	ac              [4]*huffDecoder
+	restartInterval int
+	lossless        *Samples // Current frame, if lossless.
+	scan            *scanState
+	workers         chan struct{}  // Holds a value for each running goroutine.
+	pending         sync.WaitGroup // Intervals being decoded concurrently.
+	mutex           sync.Mutex     // Protects err.
+	err             error          // First error from concurrent decoding.
+}
+
+// NewDecoder creates a new Decoder.
+func NewDecoder() *Decoder {
+	return new(Decoder)
+}
+
+// setQuantTable defines or redefines a quantization table.
+func setQuantTable(tables []QuantTable, table QuantTable) []QuantTable {
+	for i := range tables {
+		if tables[i].Dest == table.Dest {
+			tables[i] = table
+			return tables
+		}
+	}
+	return append(tables, table)
+}
+
+// setHuffmanTable defines or redefines a Huffman table.
+func setHuffmanTable(tables []HuffmanTable, table HuffmanTable) []HuffmanTable {
+	for i := range tables {
+		if tables[i].Class == table.Class && tables[i].Dest == table.Dest {
+			tables[i] = table
+			return tables
+		}
+	}
+	return append(tables, table)
+}
+
+// Process processes a marker and its segment data, or image data if
+// the marker is zero.
+func (dec *Decoder) Process(marker Marker, buf []byte) error {
+	return dec.process(marker, buf, -1)
+}
+
+// process implements Process. 'offset' is the position of image data
+// in the input, used to locate errors found when decoding it
+// concurrently, or -1 if unknown.
+func (dec *Decoder) process(marker Marker, buf []byte, offset int64) error {
+	switch {
+	case marker == 0:
+		return dec.decodeData(buf, offset)
+	case marker >= RST0 && marker <= RST7:
+		if dec.CheckRestarts {
+			return dec.checkRestart(marker)
+		}
+		return nil
+	case marker == Garbage:
+		return nil
+	}
+	if err := dec.wait(); err != nil {
+		return err
+	}
+	if dec.CheckRestarts && dec.scan != nil && dec.scan.next < dec.scan.mcus {
+		// The scan ended before all its MCUs were decoded.
+		return &SyntaxError{-1, marker, ErrRestart}
+	}
+	switch {
+	case marker == DQT:
+		tables, err := GetQuantTables(buf)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			dec.quant = setQuantTable(dec.quant, table)
+		}
+		if dec.Coefficients != nil {
+			dec.Coefficients.QuantTables = append([]QuantTable(nil), dec.quant...)
+		}
+	case marker == DHT:
+		tables, err := GetHuffmanTables(buf)
+		if err != nil {
+			return err
+		}
+		for i := range tables {
+			dec.huffman = setHuffmanTable(dec.huffman, tables[i])
+			if tables[i].Class == 0 {
+				dec.dc[tables[i].Dest] = newHuffDecoder(&tables[i])
+			} else {
+				dec.ac[tables[i].Dest] = newHuffDecoder(&tables[i])
+			}
+		}
+	case marker == DAC:
+		tables, err := GetArithmeticTables(buf)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			dec.arithmetic = setArithmeticTable(dec.arithmetic, table)
+		}
+	case marker == DRI:
+		if len(buf) != 2 {
+			return &SyntaxError{-1, DRI, ErrInvalidLength}
+		}
+		dec.restartInterval = int(buf[0])<<8 | int(buf[1])
+		if dec.Coefficients != nil {
+			dec.Coefficients.RestartInterval = dec.restartInterval
+		}
+	case marker.IsSOF():
+		return dec.startFrame(marker, buf)
+	case marker == SOS:
+		return dec.startScan(buf)
+	case marker == DNL:
+		return nil
+	case marker == DHP:
+		frame, err := GetHierarchyHeader(buf)
+		if err != nil {
+			return err
+		}
+		dec.Hierarchy = frame
+	case marker == EXP:
+		_, err := GetExpansion(buf)
+		return err
+	case marker == EOI:
+		dec.Done = true
+		dec.scan = nil
+	default:
+		dec.Segments = append(dec.Segments, Segment{marker, append([]byte(nil), buf...)})
+	}
+	return nil
+}
+
+// startFrame processes a frame header.
+func (dec *Decoder) startFrame(marker Marker, buf []byte) error {
+	frame, err := GetFrameHeader(buf)
+	if err != nil {
+		return locate(err, -1, marker)
+	}
+	process := marker.Process()
+	// Only the first frame of a hierarchical image isn't
+	// differential.
+	started := dec.Coefficients != nil || len(dec.Samples) > 0
+	if started && !process.Differential {
+		return &SyntaxError{-1, marker, ErrUnsupported}
+	}
+	if !started && process.Differential {
+		return &SyntaxError{-1, marker, ErrFrameHeader}
+	}
+	if process.Lossless {
+		if process.Arithmetic || frame.Height == 0 {
+			return &SyntaxError{-1, marker, ErrUnsupported}
+		}
+		if frame.Precision < 2 || frame.Precision > 16 {
+			return &SyntaxError{-1, marker, ErrFrameHeader}
+		}
+		dec.lossless = NewSamples(marker, frame)
+		dec.Samples = append(dec.Samples, dec.lossless)
+		return nil
+	}
+	if process.Differential || frame.Height == 0 {
+		return &SyntaxError{-1, marker, ErrUnsupported}
+	}
+	dec.Coefficients = NewCoefficients(marker, frame)
+	dec.Coefficients.QuantTables = append([]QuantTable(nil), dec.quant...)
+	dec.Coefficients.RestartInterval = dec.restartInterval
+	return nil
+}
+
+// startScan processes a scan header.
+func (dec *Decoder) startScan(buf []byte) error {
+	if dec.lossless != nil {
+		return dec.startLosslessScan(buf)
+	}
+	coeffs := dec.Coefficients
+	if coeffs == nil {
+		return &SyntaxError{-1, SOS, ErrNoFrame}
+	}
+	header, err := GetScanHeader(buf)
+	if err != nil {
+		return err
+	}
+	comps, err := coeffs.Frame.scanComponents(header)
+	if err != nil {
+		return err
+	}
+	if coeffs.HuffmanTables == nil {
+		coeffs.HuffmanTables = append([]HuffmanTable(nil), dec.huffman...)
+	}
+	state := &scanState{header: header, comps: comps, mcus: coeffs.scanMCUs(comps)}
+	state.dc = make([]*huffDecoder, len(comps))
+	state.ac = make([]*huffDecoder, len(comps))
+	state.cond = arithmeticConditioning(dec.arithmetic)
+	process := coeffs.Marker.Process()
+	progressive := process.Progressive
+	for i, sc := range header.Components {
+		needDC := !progressive || header.Ss == 0 && header.Ah == 0
+		needAC := !progressive || header.Ss > 0
+		state.dc[i] = dec.dc[sc.Td]
+		state.ac[i] = dec.ac[sc.Ta]
+		if !process.Arithmetic && (needDC && state.dc[i] == nil || needAC && state.ac[i] == nil) {
+			return &SyntaxError{-1, SOS, ErrScanComponent}
+		}
+	}
+	if progressive && (header.Ss > header.Se || header.Se > 63 || header.Ss == 0 && header.Se != 0 || header.Ss > 0 && len(comps) > 1) {
+		return &SyntaxError{-1, SOS, ErrScanHeader}
+	}
+	coeffs.Scans = append(coeffs.Scans, *header)
+	dec.scan = state
+	return nil
+}
+
+// checkRestart checks that a RST marker follows a complete restart
+// interval and has the expected number.
+func (dec *Decoder) checkRestart(marker Marker) error {
+	state := dec.scan
+	if state == nil || dec.restartInterval == 0 || marker != RST0+Marker(state.resets%8) || state.next != (state.resets+1)*dec.restartInterval || state.next >= state.mcus {
+		return &SyntaxError{-1, marker, ErrRestart}
+	}
+	state.resets++
+	return nil
+}
+
+// decodeData decodes the image data of a restart interval, or of a
+// whole scan if there are no restart intervals. 'offset' is as for
+// process.
+func (dec *Decoder) decodeData(buf []byte, offset int64) error {
+	state := dec.scan
+	if state == nil {
+		return nil
+	}
+	count := state.mcus - state.next
+	if dec.restartInterval > 0 && dec.restartInterval < count {
+		count = dec.restartInterval
+	}
+	if dec.CheckRestarts && count == 0 {
+		return newSyntaxError(ErrRestart)
+	}
+	first := state.next
+	state.next += count
+	if dec.Workers < 2 || dec.restartInterval == 0 {
+		return dec.decodeInterval(state, buf, first, count)
+	}
+	// The scanner reuses its buffer, so the data must be copied.
+	buf = append([]byte(nil), buf...)
+	if dec.workers == nil {
+		dec.workers = make(chan struct{}, dec.Workers)
+	}
+	dec.workers <- struct{}{}
+	dec.pending.Add(1)
+	go func() {
+		defer func() {
+			<-dec.workers
+			dec.pending.Done()
+		}()
+		if err := dec.decodeInterval(state, buf, first, count); err != nil {
+			err = locate(err, offset, 0)
+			dec.mutex.Lock()
+			if dec.err == nil {
+				dec.err = err
+			}
+			dec.mutex.Unlock()
+		}
+	}()
+	return nil
+}
+
+// decodeInterval decodes the data of a restart interval.
+func (dec *Decoder) decodeInterval(state *scanState, buf []byte, first, count int) error {
+	var unused int
+	var err error
+	if state.samples != nil {
+		unused, err = state.samples.decodeInterval(state, buf, first, count)
+	} else {
+		unused, err = dec.Coefficients.decodeInterval(state, buf, first, count)
+	}
+	if err != nil {
+		return err
+	}
+	if dec.CheckRestarts && unused != 0 {
+		return newSyntaxError(ErrRestart)
+	}
+	return nil
+}
+
+// wait waits for any restart intervals being decoded concurrently,
+// returning the first error encountered.
+func (dec *Decoder) wait() error {
+	dec.pending.Wait()
+	err := dec.err
+	dec.err = nil
+	return err
+}
+
+// ReadCoefficients reads a JPEG image from a scanner, up to and
+// including the EOI marker, and decodes its image data into DCT
+// coefficients. Only sequential and progressive DCT images are
+// supported, with Huffman or arithmetic coding; lossless images can be
+// read with ReadSamples. Returns the coefficients and the segments
+// that don't contain tables or frame and scan headers, such as APPn
+// and COM segments.
+func ReadCoefficients(scanner *Scanner) (*Coefficients, []Segment, error) {
+	dec := NewDecoder()
+	if err := dec.Decode(scanner); err != nil {
+		return nil, nil, err
+	}
+	if dec.Coefficients == nil || len(dec.Samples) > 0 {
+		return nil, nil, newSyntaxError(ErrUnsupported)
+	}
+	return dec.Coefficients, dec.Segments, nil
+}
+
+// Decode reads markers and segments from a scanner, up to and
+// including the EOI marker, and processes them.
+func (dec *Decoder) Decode(scanner *Scanner) error {
+	for !dec.Done {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return err
+		}
+		offset := scanner.Offsets().Marker
+		if marker == 0 {
+			offset = scanner.Offsets().Data
+		}
+		if err := dec.process(marker, buf, offset); err != nil {
+			return locate(err, offset, marker)
+		}
+	}
+	if dec.Coefficients == nil && len(dec.Samples) == 0 {
+		return &SyntaxError{scanner.Offsets().Marker, EOI, ErrNoFrame}
+	}
+	return nil
+}
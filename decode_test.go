@@ -0,0 +1,54 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// decodeWorkers decodes an image with the given number of workers,
+// checking restarts.
+func decodeWorkers(data []byte, workers int) (*Decoder, error) {
+	scanner, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dec := NewDecoder()
+	dec.Workers = workers
+	dec.CheckRestarts = true
+	return dec, dec.Decode(scanner)
+}
+
+func TestDecodeWorkers(t *testing.T) {
+	data := makeJPEG(t, 320, 240, false)
+	coeffs, segments := readCoefficients(t, data)
+	coeffs.RestartInterval = 3
+	for _, progressive := range []bool{false, true} {
+		encoded := writeCoefficients(t, coeffs, segments, &EncodeOptions{Progressive: progressive})
+		// Drop some data from the interval before RST5.
+		rst := bytes.Index(encoded, []byte{0xFF, byte(RST0 + 5)})
+		bad := append(append([]byte(nil), encoded[:rst-3]...), encoded[rst:]...)
+		var expected *SyntaxError
+		for _, workers := range []int{0, 1, 4, 8} {
+			dec, err := decodeWorkers(encoded, workers)
+			if err != nil {
+				t.Fatalf("progressive %v, %d workers: %v", progressive, workers, err)
+			}
+			sameCoefficients(t, coeffs, dec.Coefficients)
+			_, err = decodeWorkers(bad, workers)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("progressive %v, %d workers: got %v, expected a SyntaxError", progressive, workers, err)
+			}
+			if syntaxErr.Offset < 0 || syntaxErr.Offset >= int64(rst) || syntaxErr.Marker != 0 {
+				t.Errorf("progressive %v, %d workers: %v isn't located in the image data before RST5", progressive, workers, err)
+			}
+			// The error is the same however it's decoded.
+			if expected == nil {
+				expected = syntaxErr
+			} else if *syntaxErr != *expected {
+				t.Errorf("progressive %v, %d workers: got %v, expected %v", progressive, workers, err, expected)
+			}
+		}
+	}
+}
@@ -5,6 +5,24 @@ The package contains functionality for basic JPEG files and for files that use M
 
 Reading and writing JPEG segments can be done by creating "scanners" and "dumpers", which wrap unbuffered seekable input and output streams. The example jpegsegsstrip program is a simple example: it doesn't need to decode information from MPF since it processes only the first image in a file and ignores additional images if present.
 
+Input that can't be seeked, such as a pipe or network connection, can be read with a scanner created by NewStreamScanner, which reads via an internal buffer instead. Its offsets are measured from the start of the JPEG header, and MPF processors locate the MPF segment from the offsets (see Scanner.Offsets) rather than by seeking, so the MPF index can be decoded from a stream too. Visiting the additional images with MPFIndex.ImageIterate still requires a seekable input.
+
+By default, a scanner discards 0xFF fill bytes before markers and removes the 0 bytes that escape 0xFF values in image data, so that copying a file produces output that is functionally identical but not necessarily byte identical. A scanner and dumper in raw mode (see Scanner.SetRaw and Dumper.SetRaw) instead preserve these details, along with any padding after the EOI marker, allowing an unmodified file to be reproduced exactly.
+
+Scanners normally fail at the first invalid byte. A scanner in lenient mode (see Scanner.SetLenient) instead skips corrupt data up to the next marker, reporting it with the Garbage pseudo-marker, and synthesizes an EOI marker if the input is truncated, so that as much of a damaged file as possible can be recovered.
+
+The image data of a frame can be decoded into quantized DCT coefficients with ReadCoefficients and encoded again with WriteCoefficients, without conversion to pixels. This allows lossless operations such as the rotations and mirroring done by Coefficients.Transform, which is demonstrated by the jpegsegsrotate program, and cropping with Coefficients.Crop. The coding can also be changed when writing, for example by optimizing the Huffman tables or converting between sequential and progressive scans or between Huffman and arithmetic coding; see EncodeOptions and Transcode. Images with restart intervals can be decoded faster by a Decoder with Workers set, which decodes the intervals concurrently.
+
+Lossless images, which use prediction instead of the DCT, are decoded into samples by ReadSamples. In hierarchical images, which contain a sequence of frames at increasing resolutions described by a DHP segment, each frame is decoded separately; Scanner.Frame and NextFrame identify the frames.
+
+Exif metadata is stored in an APP1 segment in TIFF format, like MPF. GetExifTree decodes it into a TIFF tree using the tiff66 package, and MakeExifSegment encodes an edited tree into a new segment; an ExifRewriter does both while segments are being copied. The JPEG thumbnail in IFD1 can be extracted, replaced or dropped with ExifThumbnail, SetExifThumbnail and DropExifThumbnail.
+
+XMP packets are found in APP1 segments by GetXMP, and packets too large for one segment have Extended XMP that's reassembled and checked against its GUID by GetFullXMP. SetXMP replaces both in a list of segments, splitting the Extended XMP into chunks.
+
+An ICC profile is split into numbered chunks in APP2 segments. GetICCProfile reassembles it from a list of segments, checking the numbering, CheckICCProfile checks its header, and SetICCProfile replaces or removes it.
+
+JFIF APP0 segments, which give the pixel density, and JFXX segments with extension thumbnails are decoded by GetJFIF and GetJFXX. InsertJFIF adds a minimal JFIF segment to images without one, for programs that require it.
+
 Processing files that use MPF is more complex. The MPF information is stored in APP2 segments in TIFF format; the MPF segment in the first file starts with index information. The index gives the offsets and lengths of the individual images. Reading the images can be done by unpacking the MPF index and seeking the input stream to each image in turn. This is demonstrated by the jpegsegsprint program.
 
 Writing a multi-image file with MPF requires that the file positions of all images be encoded into the MPF index. The approach taken here is to initially write the index into the first image with nominal values, to reserve the appropriate amount of space in the APP2 segment. After all images have been written to the output, and the positions collected, the APP2 segment is then rewritten with the final positions. This is demonstrated by the jpegsegscopy program.
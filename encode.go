@@ -0,0 +1,488 @@
+package jpegsegs
+
+import (
+	"errors"
+)
+
+// EncodeOptions controls the encoding done by WriteCoefficients.
+type EncodeOptions struct {
+	// HuffmanTables are the Huffman tables used for encoding. If
+	// nil, the coefficients' own HuffmanTables are used, or if
+	// they're nil or can't code every value, tables optimized for
+	// the image. The standard tables from Annex K can't code all
+	// values with precision other than 8, so aren't used unless
+	// given here. The first component uses the DC and AC tables
+	// with destination 0 and the other components use destination
+	// 1 if defined, otherwise 0.
+	HuffmanTables []HuffmanTable
+	// OptimizeHuffman specifies that Huffman tables optimized for
+	// the image should be used instead of HuffmanTables.
+	OptimizeHuffman bool
+	// Progressive specifies that the image should be encoded with
+	// the progressive process instead of sequentially. Each scan
+	// is preceded by Huffman tables optimized for it, so
+	// HuffmanTables and OptimizeHuffman are ignored.
+	Progressive bool
+	// Scans is the scan script for progressive encoding. If nil,
+	// the script returned by ProgressiveScript is used. The table
+	// selectors in the scan headers are ignored.
+	Scans []ScanHeader
+	// Arithmetic specifies that arithmetic coding should be used
+	// instead of Huffman coding, with the default conditioning
+	// tables. HuffmanTables and OptimizeHuffman are ignored.
+	Arithmetic bool
+}
+
+// isBaseline checks if coefficients can be encoded with the baseline
+// process, given the Huffman tables to be used.
+func (coeffs *Coefficients) isBaseline(tables []HuffmanTable) bool {
+	if coeffs.Frame.Precision != 8 {
+		return false
+	}
+	for _, table := range coeffs.QuantTables {
+		if table.Precision != 0 {
+			return false
+		}
+	}
+	for _, table := range tables {
+		if table.Dest > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// hasDest1 checks if a list of Huffman tables includes a table with
+// destination 1.
+func hasDest1(tables []HuffmanTable) bool {
+	for _, table := range tables {
+		if table.Dest == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// tableDest returns the destination of the Huffman tables used for a
+// frame component: 0 for the first component, and for the others 1 if
+// 'dest1' is set, otherwise 0.
+func tableDest(comp int, dest1 bool) uint8 {
+	if comp > 0 && dest1 {
+		return 1
+	}
+	return 0
+}
+
+// sequentialScans returns scan headers for sequential encoding: a
+// single interleaved scan if possible, otherwise one scan for each
+// component. 'dest1' is passed to tableDest.
+func (coeffs *Coefficients) sequentialScans(dest1 bool) []*ScanHeader {
+	blocks := 0
+	for _, comp := range coeffs.Components {
+		blocks += int(comp.H) * int(comp.V)
+	}
+	interleave := len(coeffs.Components) <= 4 && blocks <= 10
+	var scans []*ScanHeader
+	for i, comp := range coeffs.Components {
+		dest := tableDest(i, dest1)
+		sc := ScanComponent{comp.ID, dest, dest}
+		if interleave && i > 0 {
+			scans[0].Components = append(scans[0].Components, sc)
+		} else {
+			scans = append(scans, &ScanHeader{Components: []ScanComponent{sc}, Se: 63})
+		}
+	}
+	return scans
+}
+
+// encodeHuffmanTables prepares Huffman tables for encoding, indexed
+// by class and destination.
+func encodeHuffmanTables(tables []HuffmanTable) [2][4]*huffEncoder {
+	var encoders [2][4]*huffEncoder
+	for i := range tables {
+		encoders[tables[i].Class&1][tables[i].Dest&3] = newHuffEncoder(&tables[i])
+	}
+	return encoders
+}
+
+// optimalTables returns Huffman tables optimized for encoding the
+// given scans, with the destinations used in the scan headers.
+func (coeffs *Coefficients) optimalTables(scans []*ScanHeader, progressive bool) ([]HuffmanTable, error) {
+	var freqs [2][4][256]int
+	var encoders [2][4]*huffEncoder
+	for class := range freqs {
+		for dest := range freqs[class] {
+			encoders[class][dest] = &huffEncoder{freq: &freqs[class][dest]}
+		}
+	}
+	for _, scan := range scans {
+		if _, err := coeffs.encodeScan(scan, encoders, progressive); err != nil {
+			return nil, err
+		}
+	}
+	var tables []HuffmanTable
+	for dest := range freqs[0] {
+		for class := range freqs {
+			if freqs[class][dest] != [256]int{} {
+				tables = append(tables, OptimalHuffmanTable(uint8(class), uint8(dest), &freqs[class][dest]))
+			}
+		}
+	}
+	return tables, nil
+}
+
+// OptimalHuffmanTables returns Huffman tables optimized for encoding
+// the coefficients as sequential scans, as done by WriteCoefficients.
+// The first component uses tables with destination 0 and the others
+// destination 1.
+func (coeffs *Coefficients) OptimalHuffmanTables() ([]HuffmanTable, error) {
+	return coeffs.optimalTables(coeffs.sequentialScans(true), false)
+}
+
+// encodeScan encodes the blocks of a scan, returning the Huffman-coded
+// data of each restart interval, without 0xFF escapes.
+func (coeffs *Coefficients) encodeScan(scan *ScanHeader, encoders [2][4]*huffEncoder, progressive bool) ([][]byte, error) {
+	comps, err := coeffs.Frame.scanComponents(scan)
+	if err != nil {
+		return nil, err
+	}
+	dc := make([]*huffEncoder, len(comps))
+	ac := make([]*huffEncoder, len(comps))
+	for i, sc := range scan.Components {
+		needDC := !progressive || scan.Ss == 0 && scan.Ah == 0
+		needAC := !progressive || scan.Ss > 0
+		dc[i] = encoders[0][sc.Td]
+		ac[i] = encoders[1][sc.Ta]
+		if needDC && dc[i] == nil || needAC && ac[i] == nil {
+			return nil, &SyntaxError{-1, SOS, ErrScanComponent}
+		}
+	}
+	mcus := coeffs.scanMCUs(comps)
+	interval := coeffs.RestartInterval
+	if interval == 0 {
+		interval = mcus
+	}
+	var intervals [][]byte
+	for first := 0; first < mcus; first += interval {
+		var bw bitWriter
+		var pred [4]int32
+		pe := progressiveEncoder{bw: &bw, ac: ac[0]}
+		for mcu := first; mcu < first+interval && mcu < mcus; mcu++ {
+			err := coeffs.mcuBlocks(scan, comps, mcu, func(i int, block *Block) error {
+				switch {
+				case !progressive:
+					return encodeSequential(&bw, block, &pred[i], dc[i], ac[i])
+				case scan.Ss == 0 && scan.Ah == 0:
+					return encodeDCFirst(&bw, block, &pred[i], dc[i], scan)
+				case scan.Ss == 0:
+					bw.write(uint32(int32(block[0])>>scan.Al), 1)
+				case scan.Ah == 0:
+					return pe.encodeACFirst(block, scan)
+				default:
+					return pe.encodeACRefine(block, scan)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := pe.flushEOBRun(); err != nil {
+			return nil, err
+		}
+		bw.flush()
+		intervals = append(intervals, bw.buf)
+	}
+	return intervals, nil
+}
+
+// dcScans returns the scan headers for a progressive DC scan of all
+// components, interleaved if possible.
+func dcScans(frame *FrameHeader, ah, al uint8) []ScanHeader {
+	blocks := 0
+	for _, comp := range frame.Components {
+		blocks += int(comp.H) * int(comp.V)
+	}
+	if len(frame.Components) <= 4 && blocks <= 10 {
+		scan := ScanHeader{Ah: ah, Al: al}
+		for _, comp := range frame.Components {
+			scan.Components = append(scan.Components, ScanComponent{ID: comp.ID})
+		}
+		return []ScanHeader{scan}
+	}
+	var scans []ScanHeader
+	for _, comp := range frame.Components {
+		scans = append(scans, ScanHeader{Components: []ScanComponent{{ID: comp.ID}}, Ah: ah, Al: al})
+	}
+	return scans
+}
+
+// acScan returns the scan header for a progressive AC scan of a
+// single component.
+func acScan(id, ss, se, ah, al uint8) ScanHeader {
+	return ScanHeader{Components: []ScanComponent{{ID: id}}, Ss: ss, Se: se, Ah: ah, Al: al}
+}
+
+// ProgressiveScript returns the default scan script for progressive
+// encoding of a frame, the same as used by libjpeg.
+func ProgressiveScript(frame *FrameHeader) []ScanHeader {
+	comps := frame.Components
+	if len(comps) == 3 {
+		y, cb, cr := comps[0].ID, comps[1].ID, comps[2].ID
+		scans := dcScans(frame, 0, 1)
+		scans = append(scans,
+			acScan(y, 1, 5, 0, 2),
+			acScan(cr, 1, 63, 0, 1),
+			acScan(cb, 1, 63, 0, 1),
+			acScan(y, 6, 63, 0, 2),
+			acScan(y, 1, 63, 2, 1))
+		scans = append(scans, dcScans(frame, 1, 0)...)
+		return append(scans,
+			acScan(cr, 1, 63, 1, 0),
+			acScan(cb, 1, 63, 1, 0),
+			acScan(y, 1, 63, 1, 0))
+	}
+	scans := dcScans(frame, 0, 1)
+	for _, comp := range comps {
+		scans = append(scans, acScan(comp.ID, 1, 5, 0, 2))
+	}
+	for _, comp := range comps {
+		scans = append(scans, acScan(comp.ID, 6, 63, 0, 2))
+	}
+	for _, comp := range comps {
+		scans = append(scans, acScan(comp.ID, 1, 63, 2, 1))
+	}
+	scans = append(scans, dcScans(frame, 1, 0)...)
+	for _, comp := range comps {
+		scans = append(scans, acScan(comp.ID, 1, 63, 1, 0))
+	}
+	return scans
+}
+
+// progressiveScans checks that a progressive scan script is valid for
+// the coefficients and codes every bit of every coefficient. Returns
+// copies of the scan headers, with table selectors set.
+func (coeffs *Coefficients) progressiveScans(script []ScanHeader) ([]*ScanHeader, error) {
+	// Lowest bit coded so far for each coefficient, or -1.
+	coded := make([][64]int, len(coeffs.Components))
+	for i := range coded {
+		for k := range coded[i] {
+			coded[i][k] = -1
+		}
+	}
+	scans := make([]*ScanHeader, len(script))
+	for i := range script {
+		scan := script[i]
+		scan.Components = append([]ScanComponent(nil), script[i].Components...)
+		scans[i] = &scan
+		comps, err := coeffs.Frame.scanComponents(&scan)
+		if err != nil || len(comps) == 0 || scan.Ss > scan.Se || scan.Se > 63 || scan.Ss == 0 && scan.Se != 0 || scan.Ss > 0 && len(comps) > 1 || scan.Al > 13 || scan.Ah != 0 && scan.Al != scan.Ah-1 {
+			return nil, ErrScanScript
+		}
+		for j, ci := range comps {
+			dest := tableDest(ci, true)
+			scan.Components[j].Td = dest
+			scan.Components[j].Ta = dest
+			if scan.Ss > 0 && coded[ci][0] < 0 {
+				return nil, ErrScanScript
+			}
+			for k := scan.Ss; k <= scan.Se; k++ {
+				if scan.Ah == 0 && coded[ci][k] >= 0 || scan.Ah != 0 && coded[ci][k] != int(scan.Ah) {
+					return nil, ErrScanScript
+				}
+				coded[ci][k] = int(scan.Al)
+			}
+		}
+	}
+	for i := range coded {
+		for k := range coded[i] {
+			if coded[i][k] != 0 {
+				return nil, ErrScanScript
+			}
+		}
+	}
+	return scans, nil
+}
+
+// encodeScans encodes sequential scans with the given Huffman tables,
+// returning the data of each scan's restart intervals.
+func (coeffs *Coefficients) encodeScans(scans []*ScanHeader, tables []HuffmanTable) ([][][]byte, error) {
+	encoders := encodeHuffmanTables(tables)
+	encoded := make([][][]byte, len(scans))
+	for i, scan := range scans {
+		intervals, err := coeffs.encodeScan(scan, encoders, false)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = intervals
+	}
+	return encoded, nil
+}
+
+// writeScan writes a scan header and the entropy-coded data of its
+// restart intervals, separated by RST markers.
+func writeScan(dumper *Dumper, scan *ScanHeader, intervals [][]byte) error {
+	if err := dumper.Dump(SOS, MakeScanSegment(scan)); err != nil {
+		return err
+	}
+	for i, data := range intervals {
+		if i > 0 {
+			if err := dumper.Dump(RST0+Marker(i-1)%8, nil); err != nil {
+				return err
+			}
+		}
+		if err := WriteImageData(dumper.writer, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCoefficients encodes coefficients as a JPEG image, following
+// 'segments', which would typically be the APPn and COM segments
+// returned by ReadCoefficients. The tables, frame header, scans and EOI
+// marker are written after the segments. The frame uses the
+// progressive process if requested in 'options', otherwise the
+// baseline process if possible, otherwise extended sequential. Huffman
+// coding is used unless arithmetic coding is requested, by default
+// with the coefficients' own Huffman tables if possible, as described
+// for EncodeOptions. 'options' may be nil for default options.
+func WriteCoefficients(dumper *Dumper, coeffs *Coefficients, segments []Segment, options *EncodeOptions) error {
+	if options == nil {
+		options = &EncodeOptions{}
+	}
+	if coeffs.RestartInterval < 0 || coeffs.RestartInterval > 0xFFFF {
+		return ErrRestartInterval
+	}
+	tables := options.HuffmanTables
+	defaultTables := tables == nil && !options.OptimizeHuffman
+	var scans []*ScanHeader
+	var err error
+	switch {
+	case options.Progressive:
+		script := options.Scans
+		if script == nil {
+			script = ProgressiveScript(&coeffs.Frame)
+		}
+		if scans, err = coeffs.progressiveScans(script); err != nil {
+			return err
+		}
+	case options.Arithmetic:
+	case options.OptimizeHuffman:
+		if tables, err = coeffs.OptimalHuffmanTables(); err != nil {
+			return err
+		}
+	case tables == nil:
+		tables = coeffs.HuffmanTables
+	}
+	if scans == nil {
+		scans = coeffs.sequentialScans(options.Arithmetic || hasDest1(tables))
+	}
+	// Sequential Huffman-coded scans are encoded before anything is
+	// written, so that default tables can be replaced if needed.
+	var encoded [][][]byte
+	if !options.Progressive && !options.Arithmetic {
+		if tables != nil {
+			encoded, err = coeffs.encodeScans(scans, tables)
+		}
+		unusable := tables == nil || errors.Is(err, ErrHuffmanSymbol) || errors.Is(err, ErrScanComponent)
+		if defaultTables && unusable {
+			if tables, err = coeffs.OptimalHuffmanTables(); err != nil {
+				return err
+			}
+			scans = coeffs.sequentialScans(hasDest1(tables))
+			encoded, err = coeffs.encodeScans(scans, tables)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := WriteSegments(dumper, segments); err != nil {
+		return err
+	}
+	if err := dumper.Dump(DQT, MakeQuantSegment(coeffs.QuantTables)); err != nil {
+		return err
+	}
+	marker := Marker(SOF1)
+	switch {
+	case options.Progressive && options.Arithmetic:
+		marker = SOF10
+	case options.Progressive:
+		marker = SOF2
+	case options.Arithmetic:
+		marker = SOF9
+	case coeffs.isBaseline(tables):
+		marker = SOF0
+	}
+	if err := dumper.Dump(marker, MakeFrameSegment(&coeffs.Frame)); err != nil {
+		return err
+	}
+	if options.Arithmetic {
+		var cond []ArithmeticTable
+		for dest := uint8(0); int(dest) < len(coeffs.Components) && dest < 2; dest++ {
+			cond = append(cond, DefaultArithmeticTable(0, dest), DefaultArithmeticTable(1, dest))
+		}
+		if err := dumper.Dump(DAC, MakeArithmeticSegment(cond)); err != nil {
+			return err
+		}
+	} else if !options.Progressive {
+		if err := dumper.Dump(DHT, MakeHuffmanSegment(tables)); err != nil {
+			return err
+		}
+	}
+	if coeffs.RestartInterval > 0 {
+		dri := []byte{byte(coeffs.RestartInterval >> 8), byte(coeffs.RestartInterval)}
+		if err := dumper.Dump(DRI, dri); err != nil {
+			return err
+		}
+	}
+	for i, scan := range scans {
+		if encoded != nil {
+			if err := writeScan(dumper, scan, encoded[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if options.Arithmetic {
+			intervals, err := coeffs.encodeArithmeticScan(scan, options.Progressive)
+			if err != nil {
+				return err
+			}
+			if err := writeScan(dumper, scan, intervals); err != nil {
+				return err
+			}
+			continue
+		}
+		if tables, err = coeffs.optimalTables([]*ScanHeader{scan}, true); err != nil {
+			return err
+		}
+		// A DC refinement scan doesn't use any tables.
+		if len(tables) > 0 {
+			if err := dumper.Dump(DHT, MakeHuffmanSegment(tables)); err != nil {
+				return err
+			}
+		}
+		intervals, err := coeffs.encodeScan(scan, encodeHuffmanTables(tables), true)
+		if err != nil {
+			return err
+		}
+		if err := writeScan(dumper, scan, intervals); err != nil {
+			return err
+		}
+	}
+	return dumper.Dump(EOI, nil)
+}
+
+// Transcode reads an image from a scanner, as with ReadCoefficients,
+// and writes it losslessly to a dumper, as with WriteCoefficients. For
+// example, images that use generic Huffman tables can be made smaller
+// by setting OptimizeHuffman in 'options', and arithmetic-coded images,
+// which many decoders can't read, are converted to Huffman coding
+// unless Arithmetic is set.
+func Transcode(scanner *Scanner, dumper *Dumper, options *EncodeOptions) error {
+	coeffs, segments, err := ReadCoefficients(scanner)
+	if err != nil {
+		return err
+	}
+	return WriteCoefficients(dumper, coeffs, segments, options)
+}
@@ -0,0 +1,146 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// flatTables returns Huffman tables optimized for a gray image with
+// all coefficients zero, which can't code most images.
+func flatTables(t *testing.T) []HuffmanTable {
+	frame := FrameHeader{Precision: 8, Width: 8, Height: 8, Components: []FrameComponent{{ID: 1, H: 1, V: 1}}}
+	tables, err := NewCoefficients(SOF0, &frame).OptimalHuffmanTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestHuffmanTableChoice(t *testing.T) {
+	data := makeJPEG(t, 120, 80, false)
+	flat := flatTables(t)
+	coeffs, segments := readCoefficients(t, data)
+	optimized := writeCoefficients(t, coeffs, segments, &EncodeOptions{OptimizeHuffman: true})
+	if len(optimized) >= len(data) {
+		t.Errorf("optimized image has %d bytes, original %d", len(optimized), len(data))
+	}
+	tests := []struct {
+		name      string
+		ownTables []HuffmanTable // Replaces coeffs.HuffmanTables.
+		options   *EncodeOptions
+		expected  []byte // Expected output, or nil if an error is expected.
+	}{
+		{"own tables", coeffs.HuffmanTables, nil, data},
+		{"given tables", nil, &EncodeOptions{HuffmanTables: coeffs.HuffmanTables}, data},
+		{"no own tables", nil, nil, optimized},
+		{"unusable own tables", flat, nil, optimized},
+		{"optimized", flat, &EncodeOptions{OptimizeHuffman: true}, optimized},
+		{"unusable given tables", coeffs.HuffmanTables, &EncodeOptions{HuffmanTables: flat}, nil},
+	}
+	for _, test := range tests {
+		modified := *coeffs
+		modified.HuffmanTables = test.ownTables
+		var buf bytes.Buffer
+		dumper, _ := NewDumper(&buf)
+		err := WriteCoefficients(dumper, &modified, segments, test.options)
+		if test.expected == nil {
+			if !errors.Is(err, ErrHuffmanSymbol) {
+				t.Errorf("%s: got %v, expected ErrHuffmanSymbol", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Errorf("%s: output differs from expected", test.name)
+		}
+	}
+	again, _ := readCoefficients(t, optimized)
+	sameCoefficients(t, coeffs, again)
+}
+
+func TestOptimalHuffmanTable(t *testing.T) {
+	// Frequencies from the Fibonacci sequence would give codes
+	// longer than 16 bits without adjustment.
+	var fibonacci [256]int
+	a, b := 1, 1
+	for i := 0; i < 40; i++ {
+		fibonacci[i] = a
+		a, b = b, a+b
+	}
+	var single [256]int
+	single[5] = 10
+	tests := []struct {
+		name   string
+		freq   *[256]int
+		values int
+	}{
+		{"fibonacci", &fibonacci, 40},
+		{"single value", &single, 1},
+	}
+	for _, test := range tests {
+		table := OptimalHuffmanTable(1, 0, test.freq)
+		if err := table.Validate(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if len(table.Values) != test.values {
+			t.Errorf("%s: %d values, expected %d", test.name, len(table.Values), test.values)
+		}
+	}
+}
+
+func TestProgressive(t *testing.T) {
+	spectral := []ScanHeader{
+		{Components: []ScanComponent{{ID: 1}, {ID: 2}, {ID: 3}}},
+		{Components: []ScanComponent{{ID: 1}}, Ss: 1, Se: 63},
+		{Components: []ScanComponent{{ID: 2}}, Ss: 1, Se: 63},
+		{Components: []ScanComponent{{ID: 3}}, Ss: 1, Se: 63},
+	}
+	tests := []struct {
+		name    string
+		gray    bool
+		restart int
+		script  []ScanHeader
+	}{
+		{"default script", false, 0, nil},
+		{"gray", true, 0, nil},
+		{"restarts", false, 5, nil},
+		{"spectral selection", false, 0, spectral},
+	}
+	for _, test := range tests {
+		data := makeJPEG(t, 203, 117, test.gray)
+		coeffs, segments := readCoefficients(t, data)
+		coeffs.RestartInterval = test.restart
+		progressive := writeCoefficients(t, coeffs, segments, &EncodeOptions{Progressive: true, Scans: test.script})
+		decoded, _ := readCoefficients(t, progressive)
+		if decoded.Marker != SOF2 {
+			t.Errorf("%s: %s frame, expected SOF2", test.name, decoded.Marker.Name())
+		}
+		if decoded.RestartInterval != test.restart {
+			t.Errorf("%s: restart interval %d, expected %d", test.name, decoded.RestartInterval, test.restart)
+		}
+		sameCoefficients(t, coeffs, decoded)
+		// image/jpeg can't decode progressive images with
+		// restart intervals, even those written by libjpeg.
+		if test.restart == 0 {
+			samePixels(t, data, progressive)
+		}
+		// Convert back to sequential.
+		sequential := writeCoefficients(t, decoded, segments, nil)
+		again, _ := readCoefficients(t, sequential)
+		if again.Marker != SOF0 {
+			t.Errorf("%s: %s frame after conversion, expected SOF0", test.name, again.Marker.Name())
+		}
+		sameCoefficients(t, coeffs, again)
+	}
+	// A script that doesn't code the AC coefficients of every
+	// component is rejected.
+	coeffs, segments := readCoefficients(t, makeJPEG(t, 16, 16, false))
+	var buf bytes.Buffer
+	dumper, _ := NewDumper(&buf)
+	if err := WriteCoefficients(dumper, coeffs, segments, &EncodeOptions{Progressive: true, Scans: spectral[:3]}); err != ErrScanScript {
+		t.Errorf("incomplete script: got %v, expected ErrScanScript", err)
+	}
+}
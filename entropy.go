@@ -0,0 +1,204 @@
+package jpegsegs
+
+// Bit-level reading and writing of Huffman-coded image data.
+
+// bitReader reads bits from image data with 0xFF escapes already
+// removed, as returned by Scanner.Scan. Reading past the end of the
+// data returns zero bits.
+type bitReader struct {
+	data  []byte
+	pos   int
+	acc   uint64 // Buffered bits, aligned to the most significant bit.
+	nbits uint   // Number of bits in acc.
+}
+
+// fill ensures that at least 56 bits are buffered.
+func (br *bitReader) fill() {
+	for br.nbits <= 56 {
+		var b byte
+		if br.pos < len(br.data) {
+			b = br.data[br.pos]
+		}
+		br.pos++
+		br.acc |= uint64(b) << (56 - br.nbits)
+		br.nbits += 8
+	}
+}
+
+// bits reads an n bit unsigned value, for n up to 16.
+func (br *bitReader) bits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if br.nbits < n {
+		br.fill()
+	}
+	v := int32(br.acc >> (64 - n))
+	br.acc <<= n
+	br.nbits -= n
+	return v
+}
+
+// unused returns the number of whole bytes of data that haven't been
+// read, which is negative if reading went past the end.
+func (br *bitReader) unused() int {
+	return len(br.data) - br.pos + int(br.nbits/8)
+}
+
+// receiveExtend reads an n bit value and converts it to a signed
+// value, as per the EXTEND procedure in the JPEG standard.
+func (br *bitReader) receiveExtend(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	v := br.bits(n)
+	if v < 1<<(n-1) {
+		v += -1<<n + 1
+	}
+	return v
+}
+
+// lookupBits is the number of bits used for fast Huffman decoding.
+const lookupBits = 9
+
+// huffDecoder is a Huffman table prepared for decoding.
+type huffDecoder struct {
+	lookup  [1 << lookupBits]uint16 // value<<8 | length for short codes, or 0.
+	maxcode [17]int32               // Largest code of each length, or -1.
+	valptr  [17]int32               // Index in values of a length's codes, minus its first code.
+	values  []uint8
+}
+
+// newHuffDecoder prepares a Huffman table for decoding.
+func newHuffDecoder(table *HuffmanTable) *huffDecoder {
+	d := &huffDecoder{values: table.Values}
+	code := int32(0)
+	p := int32(0)
+	for l := uint(1); l <= 16; l++ {
+		count := int32(table.Counts[l-1])
+		d.valptr[l] = p - code
+		d.maxcode[l] = code + count - 1
+		if l <= lookupBits {
+			shift := lookupBits - l
+			for i := int32(0); i < count; i++ {
+				entry := uint16(table.Values[p+i])<<8 | uint16(l)
+				for j := (code + i) << shift; j < (code+i+1)<<shift; j++ {
+					d.lookup[j] = entry
+				}
+			}
+		}
+		p += count
+		code = (code + count) << 1
+	}
+	return d
+}
+
+// decode reads a Huffman coded value.
+func (br *bitReader) decode(d *huffDecoder) (uint8, error) {
+	if br.nbits < 16 {
+		br.fill()
+	}
+	if entry := d.lookup[br.acc>>(64-lookupBits)]; entry != 0 {
+		n := uint(entry & 0xFF)
+		br.acc <<= n
+		br.nbits -= n
+		return uint8(entry >> 8), nil
+	}
+	for l := uint(lookupBits + 1); l <= 16; l++ {
+		code := int32(br.acc >> (64 - l))
+		if code <= d.maxcode[l] {
+			br.acc <<= l
+			br.nbits -= l
+			return d.values[d.valptr[l]+code], nil
+		}
+	}
+	return 0, newSyntaxError(ErrHuffmanCode)
+}
+
+// bitWriter accumulates bits for Huffman coded image data, without
+// 0xFF escapes.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64 // Pending bits, in the least significant bits.
+	nbits uint   // Number of bits in acc.
+}
+
+// write writes the low n bits of 'bits', for n up to 32.
+func (bw *bitWriter) write(bits uint32, n uint) {
+	bw.acc = bw.acc<<n | uint64(bits)&(1<<n-1)
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.nbits -= 8
+		bw.buf = append(bw.buf, byte(bw.acc>>bw.nbits))
+	}
+	bw.acc &= 1<<bw.nbits - 1
+}
+
+// flush pads the data to a byte boundary with 1 bits.
+func (bw *bitWriter) flush() {
+	if bw.nbits > 0 {
+		bw.write(0x7F, 8-bw.nbits)
+	}
+}
+
+// huffEncoder is a Huffman table prepared for encoding. Alternatively,
+// if freq is set, it gathers statistics for building an optimal table
+// instead of encoding.
+type huffEncoder struct {
+	code [256]uint16
+	size [256]uint8 // Code length for each value, or 0 if none.
+	freq *[256]int  // Number of times each value was encoded.
+}
+
+// newHuffEncoder prepares a Huffman table for encoding.
+func newHuffEncoder(table *HuffmanTable) *huffEncoder {
+	e := new(huffEncoder)
+	code := uint16(0)
+	p := 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(table.Counts[l-1]); i++ {
+			e.code[table.Values[p]] = code
+			e.size[table.Values[p]] = uint8(l)
+			code++
+			p++
+		}
+		code <<= 1
+	}
+	return e
+}
+
+// encode writes the Huffman code for a value.
+func (bw *bitWriter) encode(e *huffEncoder, value uint8) error {
+	if e.freq != nil {
+		e.freq[value]++
+		return nil
+	}
+	if e.size[value] == 0 {
+		return ErrHuffmanSymbol
+	}
+	bw.write(uint32(e.code[value]), uint(e.size[value]))
+	return nil
+}
+
+// category returns the number of bits needed for the magnitude of a
+// value, i.e., its SSSS category.
+func category(v int32) uint {
+	if v < 0 {
+		v = -v
+	}
+	n := uint(0)
+	for v != 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+// writeValue writes the low bits of a value with a given category,
+// as the inverse of receiveExtend.
+func (bw *bitWriter) writeValue(v int32, n uint) {
+	if v < 0 {
+		v--
+	}
+	bw.write(uint32(v), n)
+}
@@ -0,0 +1,110 @@
+package jpegsegs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons for a SyntaxError, which can be tested with errors.Is.
+var (
+	ErrNoSOI             = errors.New("SOI marker not found")
+	ErrMarkerExpected    = errors.New("0xFF expected in marker")
+	ErrInvalidMarker     = errors.New("Invalid marker 0")
+	ErrInvalidLength     = errors.New("Segment length less than 2")
+	ErrNoImageData       = errors.New("Expecting image data")
+	ErrImageDataOverflow = errors.New("Integer overflow while searching for marker in image data")
+	ErrMPFHeader         = errors.New("Invalid Tiff header in MPF segment")
+	ErrExifHeader        = errors.New("Invalid Tiff header in Exif segment")
+	ErrJFIF              = errors.New("Invalid JFIF segment")
+	ErrJFXX              = errors.New("Invalid JFXX segment")
+	ErrMPFCount          = errors.New("MPF image count is 0")
+	ErrMPFEntry          = errors.New("MPF Entry doesn't have 16 bytes for each image")
+	ErrMPFOffsetOverflow = errors.New("MPF offset overflow")
+	ErrMPFFirstOffset    = errors.New("First image should have an MPF offset of zero")
+	ErrMPFZeroOffset     = errors.New("Only the first image should have an MPF offset of zero")
+	ErrICCSequence       = errors.New("ICC profile chunks are missing, duplicated or misnumbered")
+	ErrICCProfile        = errors.New("ICC profile header is invalid")
+	ErrXMPChunks         = errors.New("Extended XMP chunks are missing, overlapping or inconsistent")
+	ErrXMPDigest         = errors.New("Extended XMP doesn't match the GUID in the XMP packet")
+	ErrXMPPacket         = errors.New("XMP packet has no rdf:Description element")
+	ErrFrameHeader       = errors.New("Invalid frame header")
+	ErrNoFrame           = errors.New("No frame header before scan")
+	ErrDNL               = errors.New("Missing or invalid DNL segment")
+	ErrQuantTable        = errors.New("Invalid DQT segment")
+	ErrHuffmanTable      = errors.New("Invalid DHT segment")
+	ErrHuffmanOverflow   = errors.New("Huffman table has too many codes")
+	ErrArithmeticTable   = errors.New("Invalid DAC segment")
+	ErrExpansion         = errors.New("Invalid EXP segment")
+	ErrScanHeader        = errors.New("Invalid scan header")
+	ErrHuffmanCode       = errors.New("Invalid Huffman-coded image data")
+	ErrArithmeticCode    = errors.New("Invalid arithmetic-coded image data")
+	ErrUnsupported       = errors.New("Unsupported coding process or feature")
+	ErrScanComponent     = errors.New("Scan component or table not defined")
+	ErrRestart           = errors.New("RST marker missing, misnumbered or misplaced")
+)
+
+// Errors for invalid arguments to functions that modify images.
+var (
+	ErrDataTooLong      = errors.New("Segment data is too long, max 2^16 - 3 bytes")
+	ErrICCTooLong       = errors.New("ICC profile is too long for 255 segments")
+	ErrXMPGUID          = errors.New("Extended XMP GUID should have 32 characters")
+	ErrHuffmanSymbol    = errors.New("Huffman table has no code for value")
+	ErrEmptyCrop        = errors.New("Crop rectangle doesn't intersect the image")
+	ErrScanScript       = errors.New("Scan script is invalid or doesn't code all coefficients")
+	ErrRestartInterval  = errors.New("Restart interval must be from 0 to 65535")
+	ErrThumbnailTooLong = errors.New("Exif thumbnail is too long for an APP1 segment")
+	ErrThumbnailJPEG    = errors.New("Exif thumbnail doesn't start with a JPEG SOI marker")
+)
+
+// SyntaxError describes invalid or unsupported JPEG data. Errors from
+// underlying readers and writers, such as io.EOF, are returned
+// unchanged instead.
+type SyntaxError struct {
+	Offset int64  // Position in the file where the problem was found, or -1 if unknown.
+	Marker Marker // Marker of the segment being processed, or 0 if unknown or none.
+	Reason error  // One of the Err values declared above.
+}
+
+// newSyntaxError creates a SyntaxError with the given reason, with
+// the offset and marker unknown.
+func newSyntaxError(reason error) *SyntaxError {
+	return &SyntaxError{-1, 0, reason}
+}
+
+func (e *SyntaxError) Error() string {
+	var where string
+	if e.Marker != 0 {
+		where = e.Marker.Name() + " segment"
+		if e.Offset >= 0 {
+			where += " "
+		}
+	}
+	if e.Offset >= 0 {
+		where += fmt.Sprintf("at offset %d", e.Offset)
+	}
+	if where == "" {
+		return "jpegsegs: " + e.Reason.Error()
+	}
+	return "jpegsegs: " + where + ": " + e.Reason.Error()
+}
+
+// Unwrap returns the reason for the error.
+func (e *SyntaxError) Unwrap() error {
+	return e.Reason
+}
+
+// locate sets the offset and marker of 'err' if it's a SyntaxError
+// and its offset isn't already known. The marker is only set together
+// with the offset, so that an error located in image data, which has
+// no marker, isn't attributed to a later marker. Other errors are
+// returned unchanged.
+func locate(err error, offset int64, marker Marker) error {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset < 0 {
+		syntaxErr.Offset = offset
+		if syntaxErr.Marker == 0 {
+			syntaxErr.Marker = marker
+		}
+	}
+	return err
+}
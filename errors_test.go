@@ -0,0 +1,78 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSyntaxErrorLocation(t *testing.T) {
+	data := readFile(t, "huffman.jpg")
+	dqt := int64(bytes.Index(data, []byte{0xFF, byte(DQT)}))
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int64
+		marker Marker
+		reason error
+	}{
+		{"no SOI", data[2:], 0, 0, ErrNoSOI},
+		{"marker expected", insertBefore(t, data, DQT, []byte{0}), dqt, 0, ErrMarkerExpected},
+		{"marker 0", insertBefore(t, data, DQT, []byte{0xFF, 0}), dqt, 0, ErrInvalidMarker},
+		{"length 1", insertBefore(t, data, DQT, []byte{0xFF, byte(APP0 + 5), 0, 1}), dqt + 2, APP0 + 5, ErrInvalidLength},
+	}
+	for _, test := range tests {
+		for _, stream := range []bool{false, true} {
+			var scanner *Scanner
+			var err error
+			if stream {
+				scanner, err = NewStreamScanner(streamReader{bytes.NewReader(test.data)})
+			} else {
+				scanner, err = NewScanner(bytes.NewReader(test.data))
+			}
+			if err == nil {
+				_, err = scanSegments(scanner)
+			}
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("%s, stream %v: got %v, expected a SyntaxError", test.name, stream, err)
+			}
+			if syntaxErr.Offset != test.offset || syntaxErr.Marker != test.marker || !errors.Is(err, test.reason) {
+				t.Errorf("%s, stream %v: got %v, expected %v at offset %d in %s", test.name, stream, err, test.reason, test.offset, test.marker.Name())
+			}
+		}
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	tests := []struct {
+		err     *SyntaxError
+		message string
+	}{
+		{&SyntaxError{-1, 0, ErrNoSOI}, "jpegsegs: SOI marker not found"},
+		{&SyntaxError{10, 0, ErrInvalidMarker}, "jpegsegs: at offset 10: Invalid marker 0"},
+		{&SyntaxError{-1, APP2, ErrMPFCount}, "jpegsegs: APP2 segment: MPF image count is 0"},
+		{&SyntaxError{20, DHT, ErrInvalidLength}, "jpegsegs: DHT segment at offset 20: Segment length less than 2"},
+	}
+	for _, test := range tests {
+		if test.err.Error() != test.message {
+			t.Errorf("got %q, expected %q", test.err.Error(), test.message)
+		}
+		if !errors.Is(test.err, test.err.Reason) || errors.Is(test.err, ErrDataTooLong) {
+			t.Errorf("%q: errors.Is doesn't match the reason", test.message)
+		}
+	}
+}
+
+func TestDataTooLong(t *testing.T) {
+	const max = 2<<15 - 3
+	err := WriteData(io.Discard, make([]byte, max+1))
+	var syntaxErr *SyntaxError
+	if !errors.Is(err, ErrDataTooLong) || errors.As(err, &syntaxErr) {
+		t.Errorf("got %v, expected ErrDataTooLong outside a SyntaxError", err)
+	}
+	if err := WriteData(io.Discard, make([]byte, max)); err != nil {
+		t.Error(err)
+	}
+}
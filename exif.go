@@ -0,0 +1,245 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"fmt"
+	tiff "github.com/garyhouston/tiff66"
+)
+
+// ExifHeader is the header at the start of an Exif APP1 segment,
+// which is followed by a TIFF structure.
+var ExifHeader = []byte("Exif\000\000")
+
+// ExifHeaderSize is the length of ExifHeader.
+const ExifHeaderSize = 6
+
+// GetExifHeader checks if a slice starts with an Exif header, as found
+// in a JPEG APP1 segment. Returns a flag and the position of the next
+// byte.
+func GetExifHeader(buf []byte) (bool, uint32) {
+	if bytes.HasPrefix(buf, ExifHeader) {
+		return true, ExifHeaderSize
+	}
+	return false, 0
+}
+
+// PutExifHeader puts an Exif header at the start of a slice, returning
+// the position of the next byte.
+func PutExifHeader(buf []byte) uint32 {
+	copy(buf, ExifHeader)
+	return ExifHeaderSize
+}
+
+// GetExifTree reads a TIFF structure with Exif data. 'buf' must start
+// with the first byte of the TIFF header. IFD0 is decoded in
+// tiff.TIFFSpace, and the Exif, GPS and Interoperability IFDs that it
+// points to in tiff.ExifSpace, tiff.GPSSpace and tiff.InteropSpace.
+// The tree may refer to data in 'buf', which shouldn't be modified
+// while the tree is in use.
+func GetExifTree(buf []byte) (*tiff.IFDNode, error) {
+	valid, order, ifdpos := tiff.GetHeader(buf)
+	if !valid {
+		return nil, &SyntaxError{-1, APP1, ErrExifHeader}
+	}
+	return tiff.GetIFDTree(buf, order, ifdpos, tiff.TIFFSpace)
+}
+
+// PutExifTree packs Exif data into a slice in TIFF format. The slice
+// should start with the first byte following the Exif header. Returns
+// the position following the last byte used.
+func PutExifTree(buf []byte, exif *tiff.IFDNode) (uint32, error) {
+	tiff.PutHeader(buf, exif.Order, tiff.HeaderSize)
+	return exif.PutIFDTree(buf, tiff.HeaderSize)
+}
+
+// MakeExifSegment serializes an Exif TIFF tree into a newly allocated
+// slice, which can be used as an APP1 JPEG segment. Returns an error if
+// the tree is too large for a segment.
+func MakeExifSegment(tree *tiff.IFDNode) ([]byte, error) {
+	size := ExifHeaderSize + tiff.HeaderSize + tree.TreeSize()
+	if size > MaxDataSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, size)
+	}
+	buf := make([]byte, size)
+	next := PutExifHeader(buf)
+	if _, err := PutExifTree(buf[next:], tree); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ExifProcessor is an interface that provides a function for
+// processing Exif APP1 segments, analogous to MPFProcessor. 'seg' is a
+// slice containing a JPEG APP1 data segment, as returned by
+// Scanner.Scan. It returns a bool indicating whether an Exif segment
+// was processed, the APP1 data segment, possibly modified, and an
+// error value.
+type ExifProcessor interface {
+	ProcessAPP1(seg []byte) (bool, []byte, error)
+}
+
+// ExifGetTree conforms to the ExifProcessor interface. It decodes the
+// Exif TIFF tree from a segment without modifying the segment.
+type ExifGetTree struct {
+	Tree *tiff.IFDNode // Unpacked Exif TIFF tree.
+}
+
+func (exif *ExifGetTree) ProcessAPP1(seg []byte) (bool, []byte, error) {
+	isExif, next := GetExifHeader(seg)
+	if isExif {
+		// Copy the segment, since the scanner reuses its
+		// buffer and the tree may refer to it.
+		tree, err := GetExifTree(append([]byte(nil), seg[next:]...))
+		if err != nil {
+			return false, nil, err
+		}
+		exif.Tree = tree
+	}
+	return isExif, seg, nil
+}
+
+// ExifRewriter conforms to the ExifProcessor interface. It decodes the
+// Exif TIFF tree from a segment, calls Edit, if set, to modify it, and
+// reencodes it to a new segment.
+type ExifRewriter struct {
+	Tree *tiff.IFDNode                  // Unpacked Exif TIFF tree.
+	Edit func(tree *tiff.IFDNode) error // Function to modify the tree, or nil.
+}
+
+func (exif *ExifRewriter) ProcessAPP1(seg []byte) (bool, []byte, error) {
+	isExif, next := GetExifHeader(seg)
+	if isExif {
+		// Copy the segment before decoding, since the tree
+		// may contain pointers into it that will be
+		// invalidated if the original slice is changed.
+		tree, err := GetExifTree(append([]byte(nil), seg[next:]...))
+		if err != nil {
+			return false, nil, err
+		}
+		tree.Fix()
+		if exif.Edit != nil {
+			if err := exif.Edit(tree); err != nil {
+				return false, nil, err
+			}
+		}
+		if seg, err = MakeExifSegment(tree); err != nil {
+			return false, nil, err
+		}
+		exif.Tree = tree
+	}
+	return isExif, seg, nil
+}
+
+// Tags used by ExifOrientation, SetExifOrientation and
+// SetExifDimensions.
+const (
+	exifOrientation     = 0x0112
+	exifIFDPointer      = 0x8769
+	exifPixelXDimension = 0xA002
+	exifPixelYDimension = 0xA003
+)
+
+// setLongField sets a field to a single LONG value, adding it if not
+// present and keeping the fields in tag order.
+func setLongField(node *tiff.IFDNode, tag tiff.Tag, value uint32) {
+	field := tiff.Field{Tag: tag, Type: tiff.LONG, Count: 1, Data: make([]byte, 4)}
+	field.PutLong(value, 0, node.Order)
+	fields := node.Fields
+	i := 0
+	for i < len(fields) && fields[i].Tag < tag {
+		i++
+	}
+	if i < len(fields) && fields[i].Tag == tag {
+		fields[i] = field
+		return
+	}
+	fields = append(fields, tiff.Field{})
+	copy(fields[i+1:], fields[i:])
+	fields[i] = field
+	node.Fields = fields
+}
+
+// setExifValue sets an existing field to a single SHORT value, or
+// LONG if the value is too large or the field was already a LONG.
+// Returns false if the field wasn't found.
+func setExifValue(node *tiff.IFDNode, tag tiff.Tag, value uint32) bool {
+	for i, field := range node.Fields {
+		if field.Tag != tag {
+			continue
+		}
+		if field.Type == tiff.LONG || value > 0xFFFF {
+			setLongField(node, tag, value)
+			return true
+		}
+		// Don't modify the old data in place, since it may be
+		// part of a segment.
+		field = tiff.Field{Tag: tag, Type: tiff.SHORT, Count: 1, Data: make([]byte, 2)}
+		field.PutShort(uint16(value), 0, node.Order)
+		node.Fields[i] = field
+		return true
+	}
+	return false
+}
+
+// exifIFD returns the Exif IFD of an Exif TIFF tree, or nil if there's
+// none.
+func exifIFD(tree *tiff.IFDNode) *tiff.IFDNode {
+	for _, sub := range tree.SubIFDs {
+		if sub.Tag == exifIFDPointer {
+			return sub.Node
+		}
+	}
+	return nil
+}
+
+// ExifOrientation returns the Orientation tag from IFD0 of an Exif
+// TIFF tree, or 0 if it's not present.
+func ExifOrientation(tree *tiff.IFDNode) uint16 {
+	for _, field := range tree.Fields {
+		if field.Tag == exifOrientation && field.Type == tiff.SHORT && field.Count > 0 {
+			return field.Short(0, tree.Order)
+		}
+	}
+	return 0
+}
+
+// SetExifOrientation modifies the Orientation tag in IFD0 of an Exif
+// TIFF tree. Returns false if the tag wasn't found.
+func SetExifOrientation(tree *tiff.IFDNode, orientation uint16) bool {
+	return setExifValue(tree, exifOrientation, uint32(orientation))
+}
+
+// SetExifDimensions modifies the PixelXDimension and PixelYDimension
+// tags in the Exif IFD of an Exif TIFF tree. Returns false if neither
+// tag was found.
+func SetExifDimensions(tree *tiff.IFDNode, width, height uint32) bool {
+	exif := exifIFD(tree)
+	if exif == nil {
+		return false
+	}
+	foundX := setExifValue(exif, exifPixelXDimension, width)
+	foundY := setExifValue(exif, exifPixelYDimension, height)
+	return foundX || foundY
+}
+
+// UpdateExifDimensions sets the pixel dimensions in any Exif APP1
+// segments in a list to the dimensions of a frame, for example after
+// it has been cropped or rotated. The segments are replaced by
+// reencoded ones.
+func UpdateExifDimensions(segments []Segment, frame *FrameHeader) error {
+	exif := ExifRewriter{Edit: func(tree *tiff.IFDNode) error {
+		SetExifDimensions(tree, uint32(frame.Width), uint32(frame.Height))
+		return nil
+	}}
+	for i, segment := range segments {
+		if segment.Marker != APP1 {
+			continue
+		}
+		_, buf, err := exif.ProcessAPP1(segment.Data)
+		if err != nil {
+			return err
+		}
+		segments[i].Data = buf
+	}
+	return nil
+}
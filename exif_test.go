@@ -0,0 +1,232 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	tiff "github.com/garyhouston/tiff66"
+	"testing"
+)
+
+// shortField makes a field with a single SHORT value.
+func shortField(tag tiff.Tag, value uint16, order binary.ByteOrder) tiff.Field {
+	field := tiff.Field{Tag: tag, Type: tiff.SHORT, Count: 1, Data: make([]byte, 2)}
+	field.PutShort(value, 0, order)
+	return field
+}
+
+// exifTestTree makes an Exif tree with an Orientation tag and Exif IFD
+// pointer in IFD0 and pixel dimensions in the Exif IFD.
+func exifTestTree(order binary.ByteOrder) *tiff.IFDNode {
+	var exif tiff.IFDNode
+	exif.Order = order
+	exif.Space = tiff.ExifSpace
+	exif.Fields = []tiff.Field{
+		shortField(exifPixelXDimension, 640, order),
+		shortField(exifPixelYDimension, 480, order)}
+	var tree tiff.IFDNode
+	tree.Order = order
+	tree.Space = tiff.TIFFSpace
+	pointer := tiff.Field{Tag: exifIFDPointer, Type: tiff.LONG, Count: 1, Data: make([]byte, 4)}
+	tree.Fields = []tiff.Field{shortField(exifOrientation, 6, order), pointer}
+	tree.SubIFDs = []tiff.SubIFD{{Tag: exifIFDPointer, Node: &exif}}
+	return &tree
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		tree := exifTestTree(order)
+		data := tree.Fields[0].Data
+		if orientation := ExifOrientation(tree); orientation != 6 {
+			t.Fatalf("orientation %d, expected 6", orientation)
+		}
+		if !SetExifOrientation(tree, 1) || ExifOrientation(tree) != 1 {
+			t.Fatalf("orientation %d after setting it to 1", ExifOrientation(tree))
+		}
+		if order.Uint16(data) != 6 {
+			t.Error("old field data was modified")
+		}
+		tree.Fields = nil
+		if ExifOrientation(tree) != 0 || SetExifOrientation(tree, 1) {
+			t.Error("orientation found in empty IFD")
+		}
+	}
+}
+
+func TestExifDimensions(t *testing.T) {
+	tests := []struct {
+		width, height uint32
+		types         [2]tiff.Type // Expected types of the fields.
+	}{
+		{100, 200, [2]tiff.Type{tiff.SHORT, tiff.SHORT}},
+		{70000, 200, [2]tiff.Type{tiff.LONG, tiff.SHORT}},
+	}
+	for _, test := range tests {
+		tree := exifTestTree(binary.BigEndian)
+		if !SetExifDimensions(tree, test.width, test.height) {
+			t.Fatal("dimensions not found")
+		}
+		exif := exifIFD(tree)
+		for i, value := range []uint32{test.width, test.height} {
+			field := exif.Fields[i]
+			got := uint32(0)
+			if field.Type == tiff.LONG {
+				got = field.Long(0, exif.Order)
+			} else {
+				got = uint32(field.Short(0, exif.Order))
+			}
+			if field.Type != test.types[i] || got != value {
+				t.Errorf("field %d: type %d value %d, expected type %d value %d", i, field.Type, got, test.types[i], value)
+			}
+		}
+	}
+	var tree tiff.IFDNode
+	if SetExifDimensions(&tree, 1, 1) {
+		t.Error("dimensions found in tree without an Exif IFD")
+	}
+}
+
+func TestExifSegment(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		seg, err := MakeExifSegment(exifTestTree(order))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(seg, ExifHeader) {
+			t.Fatalf("%v: no Exif header", order)
+		}
+		tree, err := GetExifTree(seg[ExifHeaderSize:])
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if tree.Order != order || ExifOrientation(tree) != 6 {
+			t.Errorf("%v: orientation %d", order, ExifOrientation(tree))
+		}
+		exif := exifIFD(tree)
+		if exif == nil || len(exif.Fields) != 2 || exif.Fields[0].Short(0, order) != 640 || exif.Fields[1].Short(0, order) != 480 {
+			t.Errorf("%v: Exif IFD not decoded", order)
+		}
+	}
+	if _, err := GetExifTree([]byte("XX\000\052\000\000\000\010")); !errors.Is(err, ErrExifHeader) {
+		t.Errorf("got %v for an invalid header, expected %v", err, ErrExifHeader)
+	}
+	tree := exifTestTree(binary.BigEndian)
+	tree.Fields = append(tree.Fields, tiff.Field{Tag: 0x010E, Type: tiff.ASCII, Count: MaxDataSize, Data: make([]byte, MaxDataSize)})
+	if _, err := MakeExifSegment(tree); !errors.Is(err, ErrDataTooLong) {
+		t.Errorf("got %v for an oversized tree, expected %v", err, ErrDataTooLong)
+	}
+}
+
+func TestUpdateExifDimensions(t *testing.T) {
+	seg, err := MakeExifSegment(exifTestTree(binary.LittleEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := []byte("http://ns.adobe.com/xap/1.0/\000<x:xmpmeta/>")
+	segments := []Segment{{APP1, seg}, {APP1, other}}
+	frame := &FrameHeader{8, 50, 1000, nil}
+	if err := UpdateExifDimensions(segments, frame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(segments[1].Data, other) {
+		t.Error("segment without Exif data was modified")
+	}
+	tree, err := GetExifTree(segments[0].Data[ExifHeaderSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exif := exifIFD(tree)
+	if exif == nil || exif.Fields[0].Short(0, exif.Order) != 1000 || exif.Fields[1].Short(0, exif.Order) != 50 {
+		t.Error("dimensions weren't updated")
+	}
+	bad := []Segment{{APP1, append(append([]byte(nil), ExifHeader...), "XX"...)}}
+	if err := UpdateExifDimensions(bad, frame); !errors.Is(err, ErrExifHeader) {
+		t.Errorf("got %v for an invalid segment, expected %v", err, ErrExifHeader)
+	}
+}
+
+func TestExifHeader(t *testing.T) {
+	buf := make([]byte, ExifHeaderSize+1)
+	if next := PutExifHeader(buf); next != ExifHeaderSize {
+		t.Errorf("PutExifHeader returned %d", next)
+	}
+	if ok, next := GetExifHeader(buf); !ok || next != ExifHeaderSize {
+		t.Errorf("GetExifHeader returned %v, %d", ok, next)
+	}
+	for _, buf := range [][]byte{nil, []byte("Exif"), []byte("Exif\000\001"), []byte("http://ns.adobe.com/xap/1.0/\000")} {
+		if ok, _ := GetExifHeader(buf); ok {
+			t.Errorf("%q: Exif header found", buf)
+		}
+	}
+}
+
+func TestExifProcessors(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		seg, err := MakeExifSegment(exifTestTree(order))
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := append([]byte(nil), seg...)
+		var get ExifGetTree
+		isExif, out, err := get.ProcessAPP1(seg)
+		if !isExif || err != nil || !bytes.Equal(out, original) {
+			t.Fatalf("%v: ExifGetTree returned %v, %v", order, isExif, err)
+		}
+		// The tree doesn't refer to the segment, which a scanner
+		// would reuse.
+		for i := range seg {
+			seg[i] = 0
+		}
+		if ExifOrientation(get.Tree) != 6 {
+			t.Errorf("%v: ExifGetTree found orientation %d", order, ExifOrientation(get.Tree))
+		}
+		rewriter := ExifRewriter{Edit: func(tree *tiff.IFDNode) error {
+			SetExifOrientation(tree, 3)
+			return nil
+		}}
+		isExif, out, err = rewriter.ProcessAPP1(original)
+		if !isExif || err != nil {
+			t.Fatalf("%v: ExifRewriter returned %v, %v", order, isExif, err)
+		}
+		tree, err := GetExifTree(out[ExifHeaderSize:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Order != order || ExifOrientation(tree) != 3 || ExifOrientation(rewriter.Tree) != 3 {
+			t.Errorf("%v: orientation %d after rewriting", order, ExifOrientation(tree))
+		}
+		if exif := exifIFD(tree); exif == nil || exif.Fields[0].Short(0, order) != 640 {
+			t.Errorf("%v: Exif IFD lost", order)
+		}
+	}
+	editErr := errors.New("edit failed")
+	tests := []struct {
+		name      string
+		processor ExifProcessor
+		seg       []byte
+		isExif    bool
+		err       error
+	}{
+		{"not Exif", &ExifGetTree{}, []byte("http://ns.adobe.com/xap/1.0/\000"), false, nil},
+		{"not Exif, rewriter", &ExifRewriter{}, []byte("http://ns.adobe.com/xap/1.0/\000"), false, nil},
+		{"bad TIFF header", &ExifGetTree{}, []byte("Exif\000\000XX\000\052\000\000\000\010"), false, ErrExifHeader},
+		{"bad TIFF header, rewriter", &ExifRewriter{}, []byte("Exif\000\000XX\000\052\000\000\000\010"), false, ErrExifHeader},
+		{"edit error", &ExifRewriter{Edit: func(*tiff.IFDNode) error { return editErr }}, nil, false, editErr},
+	}
+	valid, err := MakeExifSegment(exifTestTree(binary.BigEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if test.seg == nil {
+			test.seg = valid
+		}
+		isExif, out, err := test.processor.ProcessAPP1(test.seg)
+		if isExif != test.isExif || !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, %v", test.name, isExif, err)
+		}
+		if err == nil && !bytes.Equal(out, test.seg) {
+			t.Errorf("%s: segment was modified", test.name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package jpegsegs
+
+import (
+	"strings"
+)
+
+// IsSOF checks if a marker is one of the SOFn markers, which start a
+// frame header.
+func (m Marker) IsSOF() bool {
+	return m >= SOF0 && m <= SOF15 && m != DHT && m != JPG && m != DAC
+}
+
+// CodingProcess describes the coding process used for a frame, as
+// indicated by its SOFn marker.
+type CodingProcess struct {
+	Baseline     bool // Baseline sequential DCT, SOF0.
+	Progressive  bool // Progressive DCT.
+	Lossless     bool // Lossless, using prediction instead of DCT.
+	Arithmetic   bool // Arithmetic coding, otherwise Huffman coding.
+	Differential bool // Differential frame in a hierarchical image.
+}
+
+// Process returns the coding process indicated by a SOFn marker. The
+// result isn't meaningful for other markers.
+func (m Marker) Process() CodingProcess {
+	n := m - SOF0
+	return CodingProcess{
+		Baseline:     m == SOF0,
+		Progressive:  n&3 == 2,
+		Lossless:     n&3 == 3,
+		Arithmetic:   n&8 != 0,
+		Differential: n&4 != 0,
+	}
+}
+
+// Extended checks if a process is extended sequential DCT, i.e.,
+// sequential DCT but not baseline.
+func (p CodingProcess) Extended() bool {
+	return !p.Baseline && !p.Progressive && !p.Lossless
+}
+
+// String describes a coding process, e.g., "progressive, Huffman".
+func (p CodingProcess) String() string {
+	var parts []string
+	if p.Differential {
+		parts = append(parts, "differential")
+	}
+	switch {
+	case p.Baseline:
+		parts = append(parts, "baseline")
+	case p.Progressive:
+		parts = append(parts, "progressive")
+	case p.Lossless:
+		parts = append(parts, "lossless")
+	default:
+		parts = append(parts, "extended sequential")
+	}
+	desc := strings.Join(parts, " ")
+	if p.Arithmetic {
+		return desc + ", arithmetic"
+	}
+	return desc + ", Huffman"
+}
+
+// FrameComponent describes an image component in a frame header.
+type FrameComponent struct {
+	ID uint8 // Component identifier.
+	H  uint8 // Horizontal sampling factor, 1-4.
+	V  uint8 // Vertical sampling factor, 1-4.
+	Tq uint8 // Quantization table destination selector, 0-3.
+}
+
+// FrameHeader holds the data from a SOFn segment.
+type FrameHeader struct {
+	Precision  uint8  // Sample precision in bits.
+	Height     uint16 // Number of lines, or 0 if given by a DNL segment.
+	Width      uint16 // Number of samples per line.
+	Components []FrameComponent
+}
+
+// frameHeaderSize is the size of a frame header, excluding the
+// component specifications.
+const frameHeaderSize = 6
+
+// GetFrameHeader decodes the data from a SOFn segment.
+func GetFrameHeader(buf []byte) (*FrameHeader, error) {
+	if len(buf) < frameHeaderSize {
+		return nil, newSyntaxError(ErrFrameHeader)
+	}
+	var frame FrameHeader
+	frame.Precision = buf[0]
+	frame.Height = uint16(buf[1])<<8 | uint16(buf[2])
+	frame.Width = uint16(buf[3])<<8 | uint16(buf[4])
+	count := int(buf[5])
+	if count == 0 || len(buf) != frameHeaderSize+3*count {
+		return nil, newSyntaxError(ErrFrameHeader)
+	}
+	frame.Components = make([]FrameComponent, count)
+	for i := range frame.Components {
+		spec := buf[frameHeaderSize+3*i:]
+		comp := FrameComponent{spec[0], spec[1] >> 4, spec[1] & 0xF, spec[2]}
+		if comp.H < 1 || comp.H > 4 || comp.V < 1 || comp.V > 4 || comp.Tq > 3 {
+			return nil, newSyntaxError(ErrFrameHeader)
+		}
+		frame.Components[i] = comp
+	}
+	return &frame, nil
+}
+
+// MakeFrameSegment encodes a frame header into a newly allocated
+// slice, which can be used as a SOFn segment.
+func MakeFrameSegment(frame *FrameHeader) []byte {
+	buf := make([]byte, frameHeaderSize+3*len(frame.Components))
+	buf[0] = frame.Precision
+	buf[1] = byte(frame.Height >> 8)
+	buf[2] = byte(frame.Height)
+	buf[3] = byte(frame.Width >> 8)
+	buf[4] = byte(frame.Width)
+	buf[5] = byte(len(frame.Components))
+	for i, comp := range frame.Components {
+		spec := buf[frameHeaderSize+3*i:]
+		spec[0] = comp.ID
+		spec[1] = comp.H<<4 | comp.V&0xF
+		spec[2] = comp.Tq
+	}
+	return buf
+}
+
+// Component returns the index of the component with the given
+// identifier, or -1 if not found.
+func (frame *FrameHeader) Component(id uint8) int {
+	for i := range frame.Components {
+		if frame.Components[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// MaxSampling returns the maximum horizontal and vertical sampling
+// factors of the frame's components.
+func (frame *FrameHeader) MaxSampling() (int, int) {
+	hmax, vmax := 1, 1
+	for _, comp := range frame.Components {
+		if int(comp.H) > hmax {
+			hmax = int(comp.H)
+		}
+		if int(comp.V) > vmax {
+			vmax = int(comp.V)
+		}
+	}
+	return hmax, vmax
+}
+
+// scanComponents finds the frame component index of each component
+// in a scan.
+func (frame *FrameHeader) scanComponents(scan *ScanHeader) ([]int, error) {
+	comps := make([]int, len(scan.Components))
+	for i, sc := range scan.Components {
+		comps[i] = frame.Component(sc.ID)
+		if comps[i] < 0 {
+			return nil, &SyntaxError{-1, SOS, ErrScanComponent}
+		}
+	}
+	if len(comps) > 1 {
+		blocks := 0
+		for _, ci := range comps {
+			blocks += int(frame.Components[ci].H) * int(frame.Components[ci].V)
+		}
+		if blocks > 10 {
+			return nil, &SyntaxError{-1, SOS, ErrScanHeader}
+		}
+	}
+	return comps, nil
+}
@@ -0,0 +1,129 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// findSegment returns the data of the first segment with a marker
+// that satisfies 'match'.
+func findSegment(t testing.TB, data []byte, match func(Marker) bool) (Marker, []byte) {
+	t.Helper()
+	segments, err := scanSegments(newTestScanner(t, data, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		if match(segment.Marker) {
+			return segment.Marker, segment.Data
+		}
+	}
+	t.Fatal("segment not found")
+	return 0, nil
+}
+
+func TestGetFrameHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		buf   []byte
+		frame *FrameHeader
+	}{
+		{"gray", []byte{8, 0, 24, 0, 40, 1, 1, 0x11, 0},
+			&FrameHeader{8, 24, 40, []FrameComponent{{1, 1, 1, 0}}}},
+		{"4:2:0", []byte{8, 1, 2, 3, 4, 3, 1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1},
+			&FrameHeader{8, 0x102, 0x304, []FrameComponent{{1, 2, 2, 0}, {2, 1, 1, 1}, {3, 1, 1, 1}}}},
+		{"height from DNL", []byte{12, 0, 0, 0, 1, 1, 5, 0x41, 3},
+			&FrameHeader{12, 0, 1, []FrameComponent{{5, 4, 1, 3}}}},
+		{"short", []byte{8, 0, 24, 0, 40}, nil},
+		{"no components", []byte{8, 0, 24, 0, 40, 0}, nil},
+		{"missing component", []byte{8, 0, 24, 0, 40, 2, 1, 0x11, 0}, nil},
+		{"extra bytes", []byte{8, 0, 24, 0, 40, 1, 1, 0x11, 0, 0}, nil},
+		{"H zero", []byte{8, 0, 24, 0, 40, 1, 1, 0x01, 0}, nil},
+		{"V five", []byte{8, 0, 24, 0, 40, 1, 1, 0x15, 0}, nil},
+		{"Tq four", []byte{8, 0, 24, 0, 40, 1, 1, 0x11, 4}, nil},
+	}
+	for _, test := range tests {
+		frame, err := GetFrameHeader(test.buf)
+		if test.frame == nil {
+			if !errors.Is(err, ErrFrameHeader) {
+				t.Errorf("%s: got %v, expected %v", test.name, err, ErrFrameHeader)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(frame, test.frame) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, frame, test.frame)
+		}
+		if buf := MakeFrameSegment(frame); !bytes.Equal(buf, test.buf) {
+			t.Errorf("%s: re-encoded as %v", test.name, buf)
+		}
+	}
+}
+
+func TestFrameHeaderEncoded(t *testing.T) {
+	tests := []struct {
+		gray       bool
+		components int
+		hmax, vmax int
+	}{
+		{true, 1, 1, 1},
+		{false, 3, 2, 2},
+	}
+	for _, test := range tests {
+		marker, buf := findSegment(t, makeJPEG(t, 40, 24, test.gray), Marker.IsSOF)
+		if marker != SOF0 {
+			t.Errorf("gray %v: frame marker %s", test.gray, marker.Name())
+		}
+		frame, err := GetFrameHeader(buf)
+		if err != nil {
+			t.Fatalf("gray %v: %v", test.gray, err)
+		}
+		if frame.Precision != 8 || frame.Width != 40 || frame.Height != 24 || len(frame.Components) != test.components {
+			t.Errorf("gray %v: got %+v", test.gray, frame)
+		}
+		if h, v := frame.MaxSampling(); h != test.hmax || v != test.vmax {
+			t.Errorf("gray %v: maximum sampling %dx%d, expected %dx%d", test.gray, h, v, test.hmax, test.vmax)
+		}
+		if i := frame.Component(frame.Components[len(frame.Components)-1].ID); i != len(frame.Components)-1 {
+			t.Errorf("gray %v: Component returned %d", test.gray, i)
+		}
+		if i := frame.Component(200); i != -1 {
+			t.Errorf("gray %v: Component returned %d for a missing ID", test.gray, i)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		marker  Marker
+		isSOF   bool
+		process string
+	}{
+		{SOF0, true, "baseline, Huffman"},
+		{SOF1, true, "extended sequential, Huffman"},
+		{SOF2, true, "progressive, Huffman"},
+		{SOF3, true, "lossless, Huffman"},
+		{DHT, false, ""},
+		{SOF5, true, "differential extended sequential, Huffman"},
+		{SOF7, true, "differential lossless, Huffman"},
+		{JPG, false, ""},
+		{SOF9, true, "extended sequential, arithmetic"},
+		{SOF10, true, "progressive, arithmetic"},
+		{DAC, false, ""},
+		{SOF15, true, "differential lossless, arithmetic"},
+		{DQT, false, ""},
+	}
+	for _, test := range tests {
+		if test.marker.IsSOF() != test.isSOF {
+			t.Errorf("%s: IsSOF returned %v", test.marker.Name(), !test.isSOF)
+		}
+		if test.isSOF && test.marker.Process().String() != test.process {
+			t.Errorf("%s: process %q, expected %q", test.marker.Name(), test.marker.Process(), test.process)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package jpegsegs
+
+import (
+	"io"
+)
+
+// Support for hierarchical images, which contain a sequence of frames
+// of increasing resolution. The DHP segment gives the dimensions of
+// the final image, and an EXP segment before a differential frame
+// indicates that the reference frame is upsampled.
+
+// GetHierarchyHeader decodes the data from a DHP segment, which has the
+// same layout as a frame header with the quantization table selectors
+// set to zero. A DHP segment can be created with MakeFrameSegment.
+func GetHierarchyHeader(buf []byte) (*FrameHeader, error) {
+	frame, err := GetFrameHeader(buf)
+	if err != nil {
+		return nil, locate(err, -1, DHP)
+	}
+	return frame, nil
+}
+
+// Expansion holds the data from an EXP segment.
+type Expansion struct {
+	Horizontal bool // Reference components are expanded horizontally by 2.
+	Vertical   bool // Reference components are expanded vertically by 2.
+}
+
+// GetExpansion decodes the data from an EXP segment.
+func GetExpansion(buf []byte) (*Expansion, error) {
+	if len(buf) != 1 || buf[0]&0xEE != 0 {
+		return nil, &SyntaxError{-1, EXP, ErrExpansion}
+	}
+	return &Expansion{Horizontal: buf[0]>>4 != 0, Vertical: buf[0]&0xF != 0}, nil
+}
+
+// MakeExpansionSegment encodes expansion flags into a newly allocated
+// slice, which can be used as an EXP segment.
+func MakeExpansionSegment(exp *Expansion) []byte {
+	buf := []byte{0}
+	if exp.Horizontal {
+		buf[0] |= 0x10
+	}
+	if exp.Vertical {
+		buf[0] |= 1
+	}
+	return buf
+}
+
+// NextFrame reads markers, segments and image data from a scanner up
+// to and including the next SOFn segment, and returns its marker and
+// frame header. This allows the frames of a hierarchical image to be
+// iterated over. Returns io.EOF if the EOI marker is reached first.
+func NextFrame(scanner *Scanner) (Marker, *FrameHeader, error) {
+	for {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return 0, nil, err
+		}
+		if marker == EOI {
+			return 0, nil, io.EOF
+		}
+		if marker.IsSOF() {
+			frame, err := GetFrameHeader(buf)
+			if err != nil {
+				return 0, nil, locate(err, scanner.Offsets().Marker, marker)
+			}
+			return marker, frame, nil
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// makeSegment returns the bytes of a marker segment.
+func makeSegment(marker Marker, data []byte) []byte {
+	length := len(data) + 2
+	return append([]byte{0xFF, byte(marker), byte(length >> 8), byte(length)}, data...)
+}
+
+// makeHierarchical makes the structure of a hierarchical image from a
+// grayscale image: a DHP segment giving twice its dimensions, the
+// image itself as the first frame, and a copy as a differential frame
+// that's expanded in both directions. The image data of the second
+// frame isn't valid, but that doesn't matter to a scanner.
+func makeHierarchical(t testing.TB) []byte {
+	gray := makeJPEG(t, 20, 12, true)
+	body := gray[2 : len(gray)-2]
+	sof := bytes.Index(body, []byte{0xFF, byte(SOF0)})
+	if sof < 0 {
+		t.Fatal("SOF0 marker not found")
+	}
+	differential := append([]byte(nil), body...)
+	differential[sof+1] = byte(SOF5)
+	dhp := MakeFrameSegment(&FrameHeader{8, 24, 40, []FrameComponent{{1, 1, 1, 0}}})
+	data := []byte{0xFF, byte(SOI)}
+	data = append(data, makeSegment(DHP, dhp)...)
+	data = append(data, body...)
+	data = append(data, makeSegment(EXP, MakeExpansionSegment(&Expansion{true, true}))...)
+	data = append(data, differential...)
+	return append(data, 0xFF, byte(EOI))
+}
+
+func TestHierarchical(t *testing.T) {
+	data := makeHierarchical(t)
+	info, err := Probe(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Hierarchical || info.Width != 40 || info.Height != 24 || info.Process != Marker(SOF0).Process() {
+		t.Errorf("Probe returned %+v", info)
+	}
+	scanner := newTestScanner(t, data, false)
+	segments, err := ReadSegments(scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments[len(segments)-1].Marker != SOS || scanner.Frame() != 1 {
+		t.Errorf("ReadSegments stopped at %s in frame %d", segments[len(segments)-1].Marker.Name(), scanner.Frame())
+	}
+	scanner = newTestScanner(t, data, true)
+	expected := []struct {
+		marker Marker
+		width  uint16
+	}{
+		{SOF0, 20},
+		{SOF5, 20},
+	}
+	for i, want := range expected {
+		marker, frame, err := NextFrame(scanner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if marker != want.marker || frame.Width != want.width || scanner.Frame() != i+1 {
+			t.Errorf("frame %d: %s, width %d, Frame() %d", i+1, marker.Name(), frame.Width, scanner.Frame())
+		}
+	}
+	if _, _, err := NextFrame(scanner); err != io.EOF {
+		t.Errorf("got %v after the last frame, expected io.EOF", err)
+	}
+}
+
+func TestExpansion(t *testing.T) {
+	tests := []struct {
+		buf []byte
+		exp *Expansion
+	}{
+		{[]byte{0x00}, &Expansion{false, false}},
+		{[]byte{0x10}, &Expansion{true, false}},
+		{[]byte{0x01}, &Expansion{false, true}},
+		{[]byte{0x11}, &Expansion{true, true}},
+		{[]byte{0x02}, nil},
+		{[]byte{0x20}, nil},
+		{[]byte{0x11, 0}, nil},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		exp, err := GetExpansion(test.buf)
+		if test.exp == nil {
+			if !errors.Is(err, ErrExpansion) {
+				t.Errorf("%v: got %v, expected %v", test.buf, err, ErrExpansion)
+			}
+			continue
+		}
+		if err != nil || *exp != *test.exp {
+			t.Errorf("%v: got %+v, %v", test.buf, exp, err)
+			continue
+		}
+		if buf := MakeExpansionSegment(exp); !bytes.Equal(buf, test.buf) {
+			t.Errorf("%v: re-encoded as %v", test.buf, buf)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package jpegsegs
+
+// HuffmanTable is a Huffman coding table from a DHT segment.
+type HuffmanTable struct {
+	Class  uint8     // 0 for DC or lossless tables, 1 for AC tables.
+	Dest   uint8     // Destination identifier, 0-3.
+	Counts [16]uint8 // Number of codes of each length, 1 to 16 bits.
+	Values []uint8   // Symbol values, in order of increasing code length.
+}
+
+// GetHuffmanTables decodes the Huffman tables in a DHT segment. The
+// tables are validated with HuffmanTable.Validate.
+func GetHuffmanTables(buf []byte) ([]HuffmanTable, error) {
+	var tables []HuffmanTable
+	for pos := 0; pos < len(buf); {
+		if len(buf)-pos < 17 {
+			return nil, &SyntaxError{-1, DHT, ErrHuffmanTable}
+		}
+		var table HuffmanTable
+		table.Class = buf[pos] >> 4
+		table.Dest = buf[pos] & 0xF
+		copy(table.Counts[:], buf[pos+1:pos+17])
+		pos += 17
+		total := 0
+		for _, count := range table.Counts {
+			total += int(count)
+		}
+		if len(buf)-pos < total {
+			return nil, &SyntaxError{-1, DHT, ErrHuffmanTable}
+		}
+		table.Values = make([]uint8, total)
+		copy(table.Values, buf[pos:pos+total])
+		pos += total
+		if err := table.Validate(); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	if len(tables) == 0 {
+		return nil, &SyntaxError{-1, DHT, ErrHuffmanTable}
+	}
+	return tables, nil
+}
+
+// MakeHuffmanSegment encodes Huffman tables into a newly allocated
+// slice, which can be used as a DHT segment.
+func MakeHuffmanSegment(tables []HuffmanTable) []byte {
+	var buf []byte
+	for _, table := range tables {
+		buf = append(buf, table.Class<<4|table.Dest&0xF)
+		buf = append(buf, table.Counts[:]...)
+		buf = append(buf, table.Values...)
+	}
+	return buf
+}
+
+// Validate checks that a Huffman table is valid: the class and
+// destination are in range, the number of values matches the code
+// counts, and there are no more codes of each length than can be
+// represented (i.e., the code isn't over-subscribed.) A code consisting
+// only of 1 bits isn't allowed, and gives ErrHuffmanTable.
+func (table *HuffmanTable) Validate() error {
+	if table.Class > 1 || table.Dest > 3 {
+		return &SyntaxError{-1, DHT, ErrHuffmanTable}
+	}
+	total := 0
+	code := 0
+	for i, count := range table.Counts {
+		total += int(count)
+		code += int(count)
+		if code > 1<<uint(i+1) {
+			return &SyntaxError{-1, DHT, ErrHuffmanOverflow}
+		}
+		if code == 1<<uint(i+1) {
+			// The last code of this length is all 1 bits.
+			return &SyntaxError{-1, DHT, ErrHuffmanTable}
+		}
+		code <<= 1
+	}
+	if total != len(table.Values) || total > 256 {
+		return &SyntaxError{-1, DHT, ErrHuffmanTable}
+	}
+	return nil
+}
+
+// StandardHuffmanTables returns the example Huffman tables from
+// Annex K of the JPEG standard: DC and AC tables for luminance with
+// destination 0, and for chrominance with destination 1. These are
+// the tables assumed by Motion JPEG streams that omit DHT segments.
+func StandardHuffmanTables() []HuffmanTable {
+	tables := []HuffmanTable{
+		{0, 0,
+			[16]uint8{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+			[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{1, 0,
+			[16]uint8{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d},
+			[]uint8{
+				0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+				0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+				0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+				0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+				0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+				0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+				0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+				0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+				0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+				0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+				0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+				0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+				0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+				0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+				0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+				0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+				0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+				0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+				0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+				0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+				0xf9, 0xfa}},
+		{0, 1,
+			[16]uint8{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+			[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{1, 1,
+			[16]uint8{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77},
+			[]uint8{
+				0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+				0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+				0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+				0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+				0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+				0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+				0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+				0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+				0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+				0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+				0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+				0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+				0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+				0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+				0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+				0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+				0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+				0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+				0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+				0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+				0xf9, 0xfa}},
+	}
+	return tables
+}
+
+// AddStandardHuffmanTables makes a list of segments, as returned by
+// ReadSegments, standalone if it has no DHT segment, by inserting one
+// with the standard tables before the first SOS segment. Otherwise
+// the segments are returned unchanged.
+func AddStandardHuffmanTables(segments []Segment) []Segment {
+	sos := len(segments)
+	for i := range segments {
+		if segments[i].Marker == DHT {
+			return segments
+		}
+		if segments[i].Marker == SOS && sos == len(segments) {
+			sos = i
+		}
+	}
+	result := make([]Segment, 0, len(segments)+1)
+	result = append(result, segments[:sos]...)
+	result = append(result, Segment{DHT, MakeHuffmanSegment(StandardHuffmanTables())})
+	return append(result, segments[sos:]...)
+}
+
+// OptimalHuffmanTable builds a Huffman table for values with the
+// given frequencies, using the procedure in Annex K.2 of the JPEG
+// standard, as implemented in libjpeg. Code lengths are limited to 16
+// bits and no code consists entirely of 1 bits. Values with a
+// frequency of zero aren't assigned a code.
+func OptimalHuffmanTable(class, dest uint8, freq *[256]int) HuffmanTable {
+	var f [257]int
+	var codesize [257]int
+	var others [257]int
+	copy(f[:], freq[:])
+	// Reserve one code point for a pseudo-value, which will be
+	// removed at the end, so that no real code is all 1 bits.
+	f[256] = 1
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// Find the values with the smallest and next smallest
+		// nonzero frequencies, taking the larger value in case
+		// of ties.
+		c1, c2 := -1, -1
+		for i := range f {
+			if f[i] > 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i := range f {
+			if f[i] > 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		// Merge the two trees.
+		f[c1] += f[c2]
+		f[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+	var bits [258]int
+	for _, size := range codesize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+	// Shorten codes longer than 16 bits, per Figure K.3.
+	for i := len(bits) - 1; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Remove the code of the pseudo-value, which is one of the
+	// longest.
+	i := 16
+	for i > 0 && bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+	table := HuffmanTable{Class: class, Dest: dest}
+	for l := 1; l <= 16; l++ {
+		table.Counts[l-1] = uint8(bits[l])
+	}
+	for size := 1; size < len(bits); size++ {
+		for v := 0; v < 256; v++ {
+			if codesize[v] == size {
+				table.Values = append(table.Values, uint8(v))
+			}
+		}
+	}
+	return table
+}
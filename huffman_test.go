@@ -0,0 +1,41 @@
+package jpegsegs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHuffmanValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[int]uint8 // Number of codes of each length.
+		values int
+		err    error
+	}{
+		{"one code", map[int]uint8{1: 1}, 1, nil},
+		{"all ones", map[int]uint8{1: 2}, 2, ErrHuffmanTable},
+		{"last code all ones", map[int]uint8{2: 3, 3: 2}, 5, ErrHuffmanTable},
+		{"longest codes", map[int]uint8{16: 255}, 255, nil},
+		{"over-subscribed", map[int]uint8{2: 3, 3: 3}, 6, ErrHuffmanOverflow},
+		{"complete but for all ones", map[int]uint8{2: 3, 3: 1}, 4, nil},
+		{"value count", map[int]uint8{3: 2}, 3, ErrHuffmanTable},
+	}
+	for _, test := range tests {
+		var table HuffmanTable
+		for length, count := range test.counts {
+			table.Counts[length-1] = count
+		}
+		table.Values = make([]uint8, test.values)
+		for i := range table.Values {
+			table.Values[i] = uint8(i)
+		}
+		if err := table.Validate(); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+	}
+	for _, table := range StandardHuffmanTables() {
+		if err := table.Validate(); err != nil {
+			t.Errorf("standard table %d/%d: %v", table.Class, table.Dest, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Support for JFIF APP0 segments, and JFXX APP0 segments which hold
+// extension thumbnails.
+
+// JFIFHeader is the identifier at the start of a JFIF APP0 segment.
+var JFIFHeader = []byte("JFIF\000")
+
+// JFXXHeader is the identifier at the start of a JFXX APP0 segment.
+var JFXXHeader = []byte("JFXX\000")
+
+// JFIFHeaderSize is the length of JFIFHeader or JFXXHeader.
+const JFIFHeaderSize = 5
+
+// Density units in a JFIF segment.
+const (
+	JFIFAspectRatio       = 0 // No units, the densities only give the pixel aspect ratio.
+	JFIFDotsPerInch       = 1
+	JFIFDotsPerCentimeter = 2
+)
+
+// JFIF holds the data from a JFIF APP0 segment.
+type JFIF struct {
+	Major, Minor uint8  // Version, e.g., 1 and 2 for 1.02.
+	Units        uint8  // Density units, e.g., JFIFDotsPerInch.
+	XDensity     uint16 // Horizontal pixel density.
+	YDensity     uint16 // Vertical pixel density.
+	ThumbWidth   uint8  // Thumbnail width, or 0 if none.
+	ThumbHeight  uint8  // Thumbnail height, or 0 if none.
+	Thumbnail    []byte // Thumbnail pixels, 3 bytes of RGB each.
+}
+
+// jfifSize is the size of a JFIF segment without a thumbnail.
+const jfifSize = JFIFHeaderSize + 9
+
+// GetJFIF decodes the data from a JFIF APP0 segment.
+func GetJFIF(buf []byte) (*JFIF, error) {
+	if len(buf) < jfifSize || !bytes.HasPrefix(buf, JFIFHeader) {
+		return nil, &SyntaxError{-1, APP0, ErrJFIF}
+	}
+	var jfif JFIF
+	data := buf[JFIFHeaderSize:]
+	jfif.Major = data[0]
+	jfif.Minor = data[1]
+	jfif.Units = data[2]
+	jfif.XDensity = binary.BigEndian.Uint16(data[3:])
+	jfif.YDensity = binary.BigEndian.Uint16(data[5:])
+	jfif.ThumbWidth = data[7]
+	jfif.ThumbHeight = data[8]
+	size := 3 * int(jfif.ThumbWidth) * int(jfif.ThumbHeight)
+	if len(buf) < jfifSize+size {
+		return nil, &SyntaxError{-1, APP0, ErrJFIF}
+	}
+	jfif.Thumbnail = buf[jfifSize : jfifSize+size]
+	return &jfif, nil
+}
+
+// MakeJFIFSegment encodes JFIF data into a newly allocated slice,
+// which can be used as an APP0 segment. The thumbnail must have 3
+// bytes for each pixel.
+func MakeJFIFSegment(jfif *JFIF) ([]byte, error) {
+	size := 3 * int(jfif.ThumbWidth) * int(jfif.ThumbHeight)
+	if len(jfif.Thumbnail) != size {
+		return nil, &SyntaxError{-1, APP0, ErrJFIF}
+	}
+	if jfifSize+size > MaxDataSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, jfifSize+size)
+	}
+	buf := make([]byte, jfifSize+size)
+	copy(buf, JFIFHeader)
+	data := buf[JFIFHeaderSize:]
+	data[0] = jfif.Major
+	data[1] = jfif.Minor
+	data[2] = jfif.Units
+	binary.BigEndian.PutUint16(data[3:], jfif.XDensity)
+	binary.BigEndian.PutUint16(data[5:], jfif.YDensity)
+	data[7] = jfif.ThumbWidth
+	data[8] = jfif.ThumbHeight
+	copy(buf[jfifSize:], jfif.Thumbnail)
+	return buf, nil
+}
+
+// DPI returns the horizontal and vertical resolution in dots per
+// inch, converting from dots per centimeter if needed. Returns false
+// if the densities have no units.
+func (jfif *JFIF) DPI() (float64, float64, bool) {
+	switch jfif.Units {
+	case JFIFDotsPerInch:
+		return float64(jfif.XDensity), float64(jfif.YDensity), true
+	case JFIFDotsPerCentimeter:
+		return float64(jfif.XDensity) * 2.54, float64(jfif.YDensity) * 2.54, true
+	}
+	return 0, 0, false
+}
+
+// Extension codes in a JFXX segment.
+const (
+	JFXXJPEG    = 0x10 // Thumbnail coded using JPEG.
+	JFXXPalette = 0x11 // Thumbnail with 1 byte per pixel, indexing a palette.
+	JFXXRGB     = 0x13 // Thumbnail with 3 bytes of RGB per pixel.
+)
+
+// JFXX holds the data from a JFXX APP0 segment.
+type JFXX struct {
+	Code    uint8  // Extension code, e.g., JFXXJPEG.
+	Width   uint8  // Thumbnail width, for palette and RGB thumbnails.
+	Height  uint8  // Thumbnail height, for palette and RGB thumbnails.
+	Palette []byte // 256 RGB entries of 3 bytes, for palette thumbnails.
+	// Thumbnail holds a JPEG image from SOI to EOI, or the pixels of
+	// a palette or RGB thumbnail.
+	Thumbnail []byte
+}
+
+// jfxxPaletteSize is the size of the palette of a JFXX thumbnail.
+const jfxxPaletteSize = 3 * 256
+
+// GetJFXX decodes the data from a JFXX APP0 segment.
+func GetJFXX(buf []byte) (*JFXX, error) {
+	if len(buf) < JFIFHeaderSize+1 || !bytes.HasPrefix(buf, JFXXHeader) {
+		return nil, &SyntaxError{-1, APP0, ErrJFXX}
+	}
+	jfxx := JFXX{Code: buf[JFIFHeaderSize]}
+	data := buf[JFIFHeaderSize+1:]
+	if jfxx.Code == JFXXJPEG {
+		if !IsJPEGHeader(data) {
+			return nil, &SyntaxError{-1, APP0, ErrJFXX}
+		}
+		jfxx.Thumbnail = data
+		return &jfxx, nil
+	}
+	if len(data) < 2 {
+		return nil, &SyntaxError{-1, APP0, ErrJFXX}
+	}
+	jfxx.Width = data[0]
+	jfxx.Height = data[1]
+	data = data[2:]
+	pixels := int(jfxx.Width) * int(jfxx.Height)
+	switch jfxx.Code {
+	case JFXXPalette:
+		if len(data) < jfxxPaletteSize+pixels {
+			return nil, &SyntaxError{-1, APP0, ErrJFXX}
+		}
+		jfxx.Palette = data[:jfxxPaletteSize]
+		jfxx.Thumbnail = data[jfxxPaletteSize : jfxxPaletteSize+pixels]
+	case JFXXRGB:
+		if len(data) < 3*pixels {
+			return nil, &SyntaxError{-1, APP0, ErrJFXX}
+		}
+		jfxx.Thumbnail = data[:3*pixels]
+	default:
+		return nil, &SyntaxError{-1, APP0, ErrJFXX}
+	}
+	return &jfxx, nil
+}
+
+// MakeJFXXSegment encodes JFXX data into a newly allocated slice,
+// which can be used as an APP0 segment. The lengths of the palette and
+// thumbnail must match the extension code and dimensions.
+func MakeJFXXSegment(jfxx *JFXX) ([]byte, error) {
+	pixels := int(jfxx.Width) * int(jfxx.Height)
+	var valid bool
+	switch jfxx.Code {
+	case JFXXJPEG:
+		valid = IsJPEGHeader(jfxx.Thumbnail)
+	case JFXXPalette:
+		valid = len(jfxx.Palette) == jfxxPaletteSize && len(jfxx.Thumbnail) == pixels
+	case JFXXRGB:
+		valid = len(jfxx.Thumbnail) == 3*pixels
+	}
+	if !valid {
+		return nil, &SyntaxError{-1, APP0, ErrJFXX}
+	}
+	buf := append([]byte(nil), JFXXHeader...)
+	buf = append(buf, jfxx.Code)
+	if jfxx.Code != JFXXJPEG {
+		buf = append(buf, jfxx.Width, jfxx.Height)
+		buf = append(buf, jfxx.Palette...)
+	}
+	buf = append(buf, jfxx.Thumbnail...)
+	if len(buf) > MaxDataSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, len(buf))
+	}
+	return buf, nil
+}
+
+// isJFIFSegment checks if a segment is a JFIF APP0 segment.
+func isJFIFSegment(segment Segment) bool {
+	return segment.Marker == APP0 && bytes.HasPrefix(segment.Data, JFIFHeader)
+}
+
+// FindJFIF decodes the first JFIF segment in a list of segments.
+// Returns nil if there's none.
+func FindJFIF(segments []Segment) (*JFIF, error) {
+	for _, segment := range segments {
+		if isJFIFSegment(segment) {
+			return GetJFIF(segment.Data)
+		}
+	}
+	return nil, nil
+}
+
+// InsertJFIF returns a list of segments with a minimal JFIF segment at
+// the start, i.e., following the SOI marker, if it doesn't already
+// have a JFIF segment. The inserted segment has version 1.01, a 1:1
+// pixel aspect ratio and no thumbnail. Otherwise the list is returned
+// unchanged.
+func InsertJFIF(segments []Segment) []Segment {
+	for _, segment := range segments {
+		if isJFIFSegment(segment) {
+			return segments
+		}
+	}
+	// Version 1.01, density 1x1 and no thumbnail.
+	buf := append(append([]byte(nil), JFIFHeader...), 1, 1, JFIFAspectRatio, 0, 1, 0, 1, 0, 0)
+	return append([]Segment{{APP0, buf}}, segments...)
+}
@@ -0,0 +1,100 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestJFIF(t *testing.T) {
+	data := makeJPEG(t, 8, 8, true)
+	tests := []JFIF{
+		{Major: 1, Minor: 1, Units: JFIFAspectRatio, XDensity: 1, YDensity: 1},
+		{Major: 1, Minor: 2, Units: JFIFDotsPerCentimeter, XDensity: 118, YDensity: 59},
+		{Major: 1, Minor: 2, Units: JFIFDotsPerInch, XDensity: 300, YDensity: 300,
+			ThumbWidth: 2, ThumbHeight: 3, Thumbnail: bytes.Repeat([]byte{1, 2, 3}, 6)},
+	}
+	for _, test := range tests {
+		buf, err := MakeJFIFSegment(&test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Check that the segment can be read from an image.
+		scanner, err := NewScanner(bytes.NewReader(insertBefore(t, data, DQT, append([]byte{0xFF, byte(APP0), 0, byte(len(buf) + 2)}, buf...))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments, err := scanSegments(scanner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jfif, err := FindJFIF(segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jfif.Major != test.Major || jfif.Minor != test.Minor || jfif.Units != test.Units || jfif.XDensity != test.XDensity || jfif.YDensity != test.YDensity || jfif.ThumbWidth != test.ThumbWidth || jfif.ThumbHeight != test.ThumbHeight || !bytes.Equal(jfif.Thumbnail, test.Thumbnail) {
+			t.Errorf("decoded %+v, expected %+v", jfif, test)
+		}
+		x, y, ok := jfif.DPI()
+		if ok != (test.Units != JFIFAspectRatio) || test.Units == JFIFDotsPerCentimeter && (x != 118*2.54 || y != 59*2.54) {
+			t.Errorf("%+v: DPI %v, %v, %v", test, x, y, ok)
+		}
+	}
+	if _, err := MakeJFIFSegment(&JFIF{ThumbWidth: 1, ThumbHeight: 1}); !errors.Is(err, ErrJFIF) {
+		t.Errorf("got %v for missing thumbnail", err)
+	}
+	if _, err := GetJFIF([]byte("JFIF\000\001\002\000\000\001\000\001\001\001")); !errors.Is(err, ErrJFIF) {
+		t.Errorf("got %v for truncated thumbnail", err)
+	}
+}
+
+func TestJFXX(t *testing.T) {
+	thumbnail := makeJPEG(t, 16, 16, false)
+	tests := []JFXX{
+		{Code: JFXXJPEG, Thumbnail: thumbnail},
+		{Code: JFXXPalette, Width: 2, Height: 2, Palette: make([]byte, jfxxPaletteSize), Thumbnail: []byte{0, 1, 2, 3}},
+		{Code: JFXXRGB, Width: 1, Height: 2, Thumbnail: []byte{1, 2, 3, 4, 5, 6}},
+	}
+	for _, test := range tests {
+		buf, err := MakeJFXXSegment(&test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jfxx, err := GetJFXX(buf)
+		if err != nil {
+			t.Fatalf("code %#x: %v", test.Code, err)
+		}
+		if jfxx.Code != test.Code || jfxx.Width != test.Width || jfxx.Height != test.Height || !bytes.Equal(jfxx.Palette, test.Palette) || !bytes.Equal(jfxx.Thumbnail, test.Thumbnail) {
+			t.Errorf("code %#x: decoded data differs", test.Code)
+		}
+	}
+	bad := []JFXX{
+		{Code: JFXXJPEG, Thumbnail: []byte{1, 2}},
+		{Code: JFXXPalette, Width: 1, Height: 1, Thumbnail: []byte{0}},
+		{Code: JFXXRGB, Width: 1, Height: 1, Thumbnail: []byte{0}},
+		{Code: 0x12},
+	}
+	for _, test := range bad {
+		if _, err := MakeJFXXSegment(&test); !errors.Is(err, ErrJFXX) {
+			t.Errorf("code %#x: got %v, expected %v", test.Code, err, ErrJFXX)
+		}
+	}
+}
+
+func TestInsertJFIF(t *testing.T) {
+	dqt := Segment{DQT, nil}
+	segments := InsertJFIF([]Segment{{APP1, []byte("Exif\000\000")}, dqt})
+	if len(segments) != 3 || !isJFIFSegment(segments[0]) || len(segments[0].Data) != jfifSize {
+		t.Fatalf("JFIF segment not inserted at the start")
+	}
+	jfif, err := GetJFIF(segments[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jfif.Major != 1 || jfif.Minor != 1 || jfif.Units != JFIFAspectRatio || jfif.XDensity != 1 || jfif.YDensity != 1 || len(jfif.Thumbnail) != 0 {
+		t.Errorf("inserted %+v", jfif)
+	}
+	if again := InsertJFIF(segments); len(again) != len(segments) {
+		t.Errorf("second JFIF segment inserted")
+	}
+}
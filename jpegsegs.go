@@ -1,8 +1,8 @@
 package jpegsegs
 
 import (
+	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	tiff "github.com/garyhouston/tiff66"
 	"io"
@@ -81,6 +81,12 @@ const (
 // segment.
 type Marker uint8
 
+// Garbage is a pseudo-marker returned by Scanner.Scan in lenient
+// mode, with data that was skipped to resynchronize on the next
+// marker. A real marker can't have the value 0xFF, which indicates a
+// fill byte.
+const Garbage = 0xFF
+
 var markerNames [256]string
 
 // Initialize markerNames
@@ -132,7 +138,7 @@ const HeaderSize = 2
 
 // IsJPEGHeader indicates if buffer contains a JPEG header.
 func IsJPEGHeader(buf []byte) bool {
-	return buf[0] == 0xFF && buf[1] == SOI
+	return len(buf) >= 2 && buf[0] == 0xFF && buf[1] == SOI
 }
 
 // ReadHeader reads the JPEG header (SOI marker). Filler bytes aren't allowed.
@@ -142,21 +148,29 @@ func ReadHeader(reader io.Reader, buf []byte) error {
 		return err
 	}
 	if !IsJPEGHeader(buf) {
-		return errors.New("SOI marker not found")
+		return newSyntaxError(ErrNoSOI)
 	}
 	return nil
 }
 
 // ReadMarker reads a JPEG marker: a pair of bytes starting with 0xFF.
 func ReadMarker(reader io.Reader, buf []byte) (Marker, error) {
+	marker, _, err := readMarker(reader, buf)
+	return marker, err
+}
+
+// readMarker reads a JPEG marker, also returning the number of 0xFF
+// fill bytes that preceded it.
+func readMarker(reader io.Reader, buf []byte) (Marker, int, error) {
 	buf = buf[0:2]
 	if _, err := io.ReadFull(reader, buf); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	if buf[0] != 0xFF {
-		return 0, errors.New("0xFF expected in marker")
+		return 0, 0, newSyntaxError(ErrMarkerExpected)
 	}
 	buf = buf[1:2] // Look at the 2nd byte only.
+	fill := 0
 	for {
 		// Skip 0xFF fill bytes. Fill bytes don't seem to have
 		// any purpose, so can be discarded.
@@ -164,13 +178,23 @@ func ReadMarker(reader io.Reader, buf []byte) (Marker, error) {
 			break
 		}
 		if _, err := reader.Read(buf); err != nil {
-			return 0, err
+			return 0, 0, err
 		}
+		fill++
 	}
 	if buf[0] == 0 {
-		return 0, errors.New("Invalid marker 0")
+		return 0, 0, newSyntaxError(ErrInvalidMarker)
+	}
+	return Marker(buf[0]), fill, nil
+}
+
+// WriteFill writes 'count' 0xFF fill bytes, which may precede a marker.
+func WriteFill(writer io.Writer, count int) error {
+	if count <= 0 {
+		return nil
 	}
-	return Marker(buf[0]), nil
+	_, err := writer.Write(bytes.Repeat([]byte{0xFF}, count))
+	return err
 }
 
 // WriteMarker writes a JPEG marker: a pair of bytes starting with 0xFF.
@@ -189,6 +213,9 @@ func ReadData(reader io.Reader, buf []byte) ([]byte, error) {
 		return nil, err
 	}
 	length := int(buf[0])<<8 + int(buf[1]) - 2
+	if length < 0 {
+		return nil, newSyntaxError(ErrInvalidLength)
+	}
 	buf = buf[0:length]
 	_, err := io.ReadFull(reader, buf)
 	return buf, err
@@ -198,7 +225,7 @@ func ReadData(reader io.Reader, buf []byte) ([]byte, error) {
 func WriteData(writer io.Writer, buf []byte) error {
 	len := len(buf) + 2
 	if len >= 2<<15 {
-		return errors.New(fmt.Sprintf("writeData: data is too long (%d), max 2^16 - 3 (%d)", len-2, 2<<15-3))
+		return fmt.Errorf("%w: %d bytes", ErrDataTooLong, len-2)
 	}
 	lenbuf := make([]byte, 2)
 	lenbuf[0] = byte(len / 256)
@@ -228,8 +255,18 @@ func checkbuf(buf []byte, reqsize int) []byte {
 // ReadImageData reads image scan data up to the next marker. 'buf' is
 // either a buffer to read into, which will be reallocated if
 // required, or nil to allocate a new buffer. Returns a buffer with
-// the image data.
+// the image data. If the input ends before a marker is found, the
+// data read so far is returned along with the error.
 func ReadImageData(reader io.ReadSeeker, buf []byte) ([]byte, error) {
+	buf, _, err := readImageData(reader, buf, false)
+	return buf, err
+}
+
+// readImageData reads image scan data up to the next marker. If 'raw'
+// is true, the data is returned as stored in the file, with 0xFF bytes
+// still escaped. Also returns the number of bytes consumed from the
+// reader.
+func readImageData(reader io.ReadSeeker, buf []byte, raw bool) ([]byte, int, error) {
 	// Image data could be very large. Reading one byte at a time
 	// would be slow. Can't take a buffered reader as a paramater,
 	// since two bytes of undo are needed to drop the marker that
@@ -243,18 +280,20 @@ func ReadImageData(reader io.ReadSeeker, buf []byte) ([]byte, error) {
 	bufpos := 0
 	readpos, err := reader.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	skipped := 0
 NEXTBLOCK:
 	for {
 		if bufpos+blocksize < bufpos {
-			return nil, errors.New("Integer overflow while searching for  marker in image data")
+			return nil, 0, newSyntaxError(ErrImageDataOverflow)
 		}
 		buf = checkbuf(buf, bufpos+blocksize)
 		count, err := reader.Read(buf[bufpos : bufpos+blocksize])
 		if err != nil {
-			return nil, err
+			// Return the data found so far, in case the
+			// caller wants to salvage it.
+			return buf[:bufpos], bufpos + skipped, err
 		}
 		end := bufpos + count
 	NEXTINDEX:
@@ -266,12 +305,23 @@ NEXTBLOCK:
 			}
 			bufpos += ffpos
 			if bufpos == end-1 {
+				if count == 1 {
+					// Only the 0xFF could be read,
+					// so the input is truncated.
+					return buf[:bufpos], bufpos + skipped, io.ErrUnexpectedEOF
+				}
 				// 2nd byte is in the next block.
 				if _, err := reader.Seek(-1, io.SeekCurrent); err != nil {
-					return nil, err
+					return nil, 0, err
 				}
 				continue NEXTBLOCK
 			}
+			if buf[bufpos+1] == 0 && raw {
+				// Escaped 0xFF in data stream, keep
+				// the 0.
+				bufpos += 2
+				continue NEXTINDEX
+			}
 			if buf[bufpos+1] == 0 {
 				// Escaped 0xFF in data stream, delete
 				// the 0.
@@ -283,14 +333,87 @@ NEXTBLOCK:
 			}
 			// Found a Marker.
 			if _, err := reader.Seek(readpos+int64(bufpos+skipped), io.SeekStart); err != nil {
-				return nil, err
+				return nil, 0, err
 			}
-			return buf[:bufpos], nil
+			return buf[:bufpos], bufpos + skipped, nil
 		}
 
 	}
 }
 
+// StreamBufferSize is the size of the buffer used when reading image
+// data from a stream that doesn't support seeking.
+const StreamBufferSize = 2 << 15
+
+// ReadImageDataStream reads image scan data up to the next marker,
+// like ReadImageData, but from a buffered reader that needn't support
+// seeking. Lookahead in the reader's buffer is used to find the
+// marker that terminates the data, which is left unread. The reader's
+// buffer must be able to hold at least 2 bytes. 'buf' is either a
+// buffer to read into, or nil to allocate a new buffer. Returns a
+// buffer with the image data. If the input ends before a marker is
+// found, the data read so far is returned along with the error.
+func ReadImageDataStream(reader *bufio.Reader, buf []byte) ([]byte, error) {
+	buf, _, err := readImageDataStream(reader, buf, false)
+	return buf, err
+}
+
+// readImageDataStream reads image scan data up to the next marker
+// from a buffered reader. If 'raw' is true, the data is returned as
+// stored in the file, with 0xFF bytes still escaped. Also returns the
+// number of bytes consumed from the reader.
+func readImageDataStream(reader *bufio.Reader, buf []byte, raw bool) ([]byte, int, error) {
+	if buf == nil {
+		buf = make([]byte, 0, reader.Size())
+	} else {
+		buf = buf[:0]
+	}
+	consumed := 0
+	for {
+		// Peek blocks until the buffer is full or an error
+		// occurs, such as the end of the stream.
+		block, err := reader.Peek(reader.Size())
+		if len(block) == 0 {
+			return buf, consumed, err
+		}
+		ffpos := bytes.IndexByte(block, 0xFF)
+		if ffpos == -1 {
+			buf = append(buf, block...)
+			if _, err := reader.Discard(len(block)); err != nil {
+				return nil, 0, err
+			}
+			consumed += len(block)
+			continue
+		}
+		buf = append(buf, block[:ffpos]...)
+		if _, err := reader.Discard(ffpos); err != nil {
+			return nil, 0, err
+		}
+		consumed += ffpos
+		next, err := reader.Peek(2)
+		if len(next) < 2 {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf, consumed, err
+		}
+		if next[1] != 0 {
+			// Found a Marker, which remains in the reader.
+			return buf, consumed, nil
+		}
+		// Escaped 0xFF in data stream, delete the 0 unless
+		// raw.
+		buf = append(buf, 0xFF)
+		if raw {
+			buf = append(buf, 0)
+		}
+		if _, err := reader.Discard(2); err != nil {
+			return nil, 0, err
+		}
+		consumed += 2
+	}
+}
+
 // WriteImageData writes a block of image data.
 func WriteImageData(writer io.Writer, buf []byte) error {
 	bufpos := 0
@@ -316,8 +439,31 @@ func WriteImageData(writer io.Writer, buf []byte) error {
 // SOS marker.
 type Scanner struct {
 	reader    io.ReadSeeker
-	buf       []byte // buffer of size 2^16 - 3
-	imageData bool   // true when expecting image data: after an SOS segment or RST marker.
+	stream    *bufio.Reader // buffered input if reading from a non-seekable stream, otherwise nil.
+	buf       []byte        // buffer of size 2^16 - 3
+	imageData bool          // true when expecting image data: after an SOS segment or RST marker.
+	raw       bool          // true if scanning in raw mode, see SetRaw.
+	fill      int           // number of fill bytes before the last marker.
+	eoi       bool          // true after an EOI marker has been scanned.
+	pos       int64         // current position in the input.
+	offsets   Offsets       // location of the last item scanned.
+	lenient   bool          // true if recovering from errors, see SetLenient.
+	truncated bool          // true if the input ended unexpectedly in lenient mode.
+	skipped   []byte        // buffer for data skipped in lenient mode.
+	pending   int           // number of fill bytes consumed by atMarker but not yet returned.
+	arith     bool          // true in an arithmetic-coded frame, whose image data may be empty.
+	frames    int           // number of SOFn markers scanned.
+}
+
+// Offsets gives the location in the input of an item returned by
+// Scanner.Scan. For a seekable input, positions are relative to the
+// start of the file; for a stream they are relative to the start of
+// the JPEG header.
+type Offsets struct {
+	Marker int64 // Position of the marker, after any fill bytes, or -1 for image data.
+	Length int64 // Position of the segment length field, or -1 if none.
+	Data   int64 // Position of the segment data or image data, or -1 if none.
+	Size   int64 // Number of bytes of data in the input, including escape bytes in image data.
 }
 
 // NewScanner creates a new Scanner and checks the JPEG header.
@@ -325,38 +471,330 @@ func NewScanner(reader io.ReadSeeker) (*Scanner, error) {
 	scanner := new(Scanner)
 	scanner.reader = reader
 	scanner.buf = make([]byte, 2<<15-3)
-	if err := ReadHeader(reader, scanner.buf); err != nil {
+	pos, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return nil, err
 	}
+	if err := ReadHeader(reader, scanner.buf); err != nil {
+		return nil, locate(err, pos, 0)
+	}
+	scanner.setHeaderOffsets(pos)
+	return scanner, nil
+}
+
+// NewStreamScanner creates a new Scanner for a stream that doesn't
+// support seeking, such as a pipe or network connection, and checks
+// the JPEG header. The stream is read via a buffer of size
+// StreamBufferSize, so data beyond the end of the JPEG image may also
+// be consumed from it.
+func NewStreamScanner(reader io.Reader) (*Scanner, error) {
+	scanner := new(Scanner)
+	scanner.stream = bufio.NewReaderSize(reader, StreamBufferSize)
+	scanner.buf = make([]byte, 2<<15-3)
+	if err := ReadHeader(scanner.stream, scanner.buf); err != nil {
+		return nil, locate(err, 0, 0)
+	}
+	scanner.setHeaderOffsets(0)
 	return scanner, nil
 }
 
+// setHeaderOffsets records the location of the SOI marker at 'pos'.
+func (scanner *Scanner) setHeaderOffsets(pos int64) {
+	scanner.offsets = Offsets{pos, -1, -1, 0}
+	scanner.pos = pos + HeaderSize
+}
+
+// SetRaw selects raw mode, in which the scanner reports the input
+// exactly, so that it can be reproduced byte for byte by a Dumper in
+// raw mode. Image data is returned with 0xFF bytes still escaped by a
+// following 0 byte, the number of fill bytes preceding each marker is
+// available from Fill, and any padding following the EOI marker is
+// returned as image data, i.e., with a zero Marker, until Scan
+// returns io.EOF.
+func (scanner *Scanner) SetRaw(raw bool) {
+	scanner.raw = raw
+}
+
+// Fill returns the number of 0xFF fill bytes that preceded the marker
+// last returned by Scan.
+func (scanner *Scanner) Fill() int {
+	return scanner.fill
+}
+
+// SetLenient selects lenient mode, in which the scanner attempts to
+// recover from corrupt or truncated input. If a marker is expected but
+// not found, or is followed by a segment length less than 2, the input
+// is skipped up to the next marker and the skipped bytes are returned
+// with the Garbage pseudo-marker. If the input ends unexpectedly, any
+// partial image data is returned as usual, followed by a synthesized
+// EOI marker, with an Offsets.Marker value of -1.
+func (scanner *Scanner) SetLenient(lenient bool) {
+	scanner.lenient = lenient
+}
+
+// Frame returns the number of SOFn markers scanned so far. In a
+// hierarchical image, which contains a sequence of frames, this
+// identifies the frame that scans and image data belong to.
+func (scanner *Scanner) Frame() int {
+	return scanner.frames
+}
+
+// Offsets returns the location in the input of the item last returned
+// by Scan. Before Scan is first called, it returns the location of the
+// SOI marker.
+func (scanner *Scanner) Offsets() Offsets {
+	return scanner.offsets
+}
+
+// input returns the reader from which markers and segments are read.
+func (scanner *Scanner) input() io.Reader {
+	if scanner.stream != nil {
+		return scanner.stream
+	}
+	return scanner.reader
+}
+
+// readImageData reads image data from the scanner's input. Also
+// returns the number of bytes consumed.
+func (scanner *Scanner) readImageData() ([]byte, int, error) {
+	if scanner.stream != nil {
+		return readImageDataStream(scanner.stream, scanner.buf, scanner.raw)
+	}
+	return readImageData(scanner.reader, scanner.buf, scanner.raw)
+}
+
+// peek returns the next 'count' bytes from the input without consuming
+// them. Fewer bytes are returned, with an error, if the input ends.
+func (scanner *Scanner) peek(count int) ([]byte, error) {
+	if scanner.stream != nil {
+		return scanner.stream.Peek(count)
+	}
+	buf := scanner.buf[:count]
+	read, err := io.ReadFull(scanner.reader, buf)
+	if read > 0 {
+		if _, err := scanner.reader.Seek(int64(-read), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	return buf[:read], err
+}
+
+// atMarker checks whether the input is positioned at a valid marker,
+// possibly preceded by fill bytes. A marker followed by a segment
+// length less than 2 isn't valid. If not, it also returns the number
+// of bytes that can be skipped to make progress. Fill bytes are
+// consumed one at a time and counted in scanner.pending, so that a
+// long run of them needs no more than a few bytes of lookahead.
+func (scanner *Scanner) atMarker() (bool, int, error) {
+	for {
+		next, err := scanner.peek(4)
+		if len(next) < 2 {
+			if err == nil || err == io.EOF && (len(next) > 0 || scanner.pending > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return false, 0, err
+		}
+		if next[0] != 0xFF {
+			return false, 1, nil
+		}
+		if next[1] == 0xFF {
+			if _, err := io.ReadFull(scanner.input(), scanner.buf[:1]); err != nil {
+				return false, 0, err
+			}
+			scanner.pending++
+			continue
+		}
+		marker := Marker(next[1])
+		if marker == 0 {
+			return false, 2, nil
+		}
+		if marker == EOI || marker == TEM || marker >= RST0 && marker <= RST7 {
+			return true, 2, nil
+		}
+		// If the length is missing, the input is truncated,
+		// which is detected when the segment is read.
+		if len(next) == 4 && int(next[2])<<8+int(next[3]) < 2 {
+			return false, 2, nil
+		}
+		return true, 2, nil
+	}
+}
+
+// skipGarbage reads data up to the next valid marker, returning it
+// with the Garbage pseudo-marker. Fill bytes already consumed by
+// atMarker are included, unless they precede the valid marker.
+func (scanner *Scanner) skipGarbage() (Marker, []byte, error) {
+	skipped := scanner.skipped[:0]
+	consumed := 0
+	skipFill := func() {
+		for ; scanner.pending > 0; scanner.pending-- {
+			skipped = append(skipped, 0xFF)
+			consumed++
+		}
+	}
+	for {
+		skipFill()
+		// Raw image data ends at a 0xFF that isn't followed
+		// by 0, which may or may not be a valid marker.
+		var buf []byte
+		var count int
+		var err error
+		if scanner.stream != nil {
+			buf, count, err = readImageDataStream(scanner.stream, scanner.buf, true)
+		} else {
+			buf, count, err = readImageData(scanner.reader, scanner.buf, true)
+		}
+		scanner.buf = buf
+		skipped = append(skipped, buf...)
+		consumed += count
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return 0, nil, err
+			}
+			scanner.truncated = true
+			break
+		}
+		valid, count, err := scanner.atMarker()
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return 0, nil, err
+			}
+			skipFill()
+			scanner.truncated = true
+			break
+		}
+		if valid {
+			break
+		}
+		skipFill()
+		buf = scanner.buf[:count]
+		if _, err := io.ReadFull(scanner.input(), buf); err != nil {
+			return 0, nil, err
+		}
+		skipped = append(skipped, buf...)
+		consumed += count
+	}
+	scanner.skipped = skipped
+	scanner.offsets = Offsets{-1, -1, scanner.pos, int64(consumed)}
+	scanner.pos += int64(consumed)
+	return Garbage, skipped, nil
+}
+
+// endOfInput returns a synthesized EOI marker when the input ends
+// unexpectedly in lenient mode.
+func (scanner *Scanner) endOfInput() (Marker, []byte, error) {
+	scanner.truncated = false
+	scanner.imageData = false
+	if scanner.eoi {
+		return 0, nil, io.EOF
+	}
+	scanner.eoi = true
+	scanner.offsets = Offsets{-1, -1, -1, 0}
+	return EOI, nil, nil
+}
+
+// isTruncated checks if an error indicates that the input ended
+// unexpectedly and can be recovered from in lenient mode.
+func (scanner *Scanner) isTruncated(err error) bool {
+	return scanner.lenient && (err == io.EOF || err == io.ErrUnexpectedEOF)
+}
+
+// readPadding reads a block of data following the EOI marker.
+func (scanner *Scanner) readPadding() ([]byte, error) {
+	buf := scanner.buf[:cap(scanner.buf)]
+	count, err := io.ReadFull(scanner.input(), buf)
+	if count > 0 {
+		return buf[:count], nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return nil, err
+}
+
 // Scan reads the next JPEG data segment. Returns a zero Marker when
-// image scan data is returned. Returns a nil slice if the marker has
-// no segment data (RST0-7, EOI or TEM.)  The data buffer is only
-// valid until Scan is called again.
+// image scan data is returned, which may be empty in an
+// arithmetic-coded frame. Returns a nil slice if the marker has no
+// segment data (RST0-7, EOI or TEM.)  The data buffer is only valid
+// until Scan is called again.
 func (scanner *Scanner) Scan() (Marker, []byte, error) {
+	scanner.fill = 0
+	if scanner.truncated {
+		return scanner.endOfInput()
+	}
+	if scanner.raw && scanner.eoi {
+		buf, err := scanner.readPadding()
+		scanner.offsets = Offsets{-1, -1, scanner.pos, int64(len(buf))}
+		scanner.pos += int64(len(buf))
+		return 0, buf, err
+	}
 	if scanner.imageData {
-		var err error
-		scanner.buf, err = ReadImageData(scanner.reader, scanner.buf)
+		buf, consumed, err := scanner.readImageData()
 		if err != nil {
-			return 0, nil, err
+			if !scanner.isTruncated(err) {
+				return 0, nil, locate(err, scanner.pos, 0)
+			}
+			scanner.truncated = true
+			if len(buf) == 0 {
+				return scanner.endOfInput()
+			}
 		}
-		if len(scanner.buf) == 0 {
-			return 0, nil, errors.New("Expecting image data")
+		scanner.buf = buf
+		scanner.offsets = Offsets{-1, -1, scanner.pos, int64(consumed)}
+		scanner.pos += int64(consumed)
+		if len(scanner.buf) == 0 && !scanner.arith {
+			if scanner.lenient {
+				scanner.imageData = false
+				return scanner.Scan()
+			}
+			return 0, nil, &SyntaxError{scanner.pos, 0, ErrNoImageData}
 		}
 		scanner.imageData = false
 		return 0, scanner.buf, nil
 	} else {
-		marker, err := ReadMarker(scanner.reader, scanner.buf)
+		if scanner.lenient {
+			valid, _, err := scanner.atMarker()
+			if err != nil {
+				if scanner.isTruncated(err) {
+					return scanner.endOfInput()
+				}
+				return 0, nil, err
+			}
+			if !valid {
+				return scanner.skipGarbage()
+			}
+		}
+		marker, fill, err := readMarker(scanner.input(), scanner.buf)
 		if err != nil {
-			return 0, nil, err
+			if scanner.isTruncated(err) {
+				return scanner.endOfInput()
+			}
+			return 0, nil, locate(err, scanner.pos, 0)
+		}
+		scanner.fill = scanner.pending + fill
+		scanner.pending = 0
+		scanner.pos += int64(scanner.fill + 2)
+		scanner.offsets = Offsets{scanner.pos - 2, -1, -1, 0}
+		scanner.eoi = (marker == EOI)
+		if marker.IsSOF() {
+			scanner.arith = marker.Process().Arithmetic
+			scanner.frames++
 		}
 		scanner.imageData = (marker == SOS || marker >= RST0 && marker <= RST7)
 		if marker == EOI || marker == TEM || (marker >= RST0 && marker <= RST7) {
 			return marker, nil, nil
 		}
-		segment, err := ReadData(scanner.reader, scanner.buf)
+		segment, err := ReadData(scanner.input(), scanner.buf)
+		if err != nil {
+			if scanner.isTruncated(err) {
+				return scanner.endOfInput()
+			}
+			return marker, segment, locate(err, scanner.pos, marker)
+		}
+		scanner.offsets.Length = scanner.pos
+		scanner.offsets.Data = scanner.pos + 2
+		scanner.offsets.Size = int64(len(segment))
+		scanner.pos += int64(len(segment) + 2)
 		return marker, segment, err
 	}
 }
@@ -364,6 +802,7 @@ func (scanner *Scanner) Scan() (Marker, []byte, error) {
 // Dumper represents a writer for JPEG markers and segments.
 type Dumper struct {
 	writer io.Writer
+	raw    bool // true if writing in raw mode, see SetRaw.
 }
 
 // NewDumper creates a new Dumper and writes the JPEG header.
@@ -376,11 +815,39 @@ func NewDumper(writer io.Writer) (*Dumper, error) {
 	return dumper, nil
 }
 
+// SetRaw selects raw mode, in which image data is written exactly as
+// given, without escaping 0xFF bytes. This is intended for data
+// returned by a Scanner in raw mode.
+func (dumper *Dumper) SetRaw(raw bool) {
+	dumper.raw = raw
+}
+
+// DumpFill writes 'count' 0xFF fill bytes, which should be followed by
+// a marker. Together with raw mode, this allows a file to be
+// reproduced exactly from the output of a raw Scanner, by calling
+// DumpFill with the result of Scanner.Fill before each Dump.
+func (dumper *Dumper) DumpFill(count int) error {
+	return WriteFill(dumper.writer, count)
+}
+
 // Dump writes a marker and its data segment.
 func (dumper *Dumper) Dump(marker Marker, buf []byte) error {
 	if marker == 0 {
+		if dumper.raw {
+			_, err := dumper.writer.Write(buf)
+			return err
+		}
 		return WriteImageData(dumper.writer, buf)
 	}
+	if marker == Garbage {
+		// Data skipped by a lenient Scanner is only
+		// reproduced in raw mode.
+		if dumper.raw {
+			_, err := dumper.writer.Write(buf)
+			return err
+		}
+		return nil
+	}
 	if err := WriteMarker(dumper.writer, marker); err != nil {
 		return err
 	}
@@ -399,7 +866,11 @@ type Segment struct {
 }
 
 // ReadSegments reads a JPEG stream up to and including the SOS marker and
-// returns a slice with marker and segment data.
+// returns a slice with marker and segment data. In a hierarchical image,
+// which has a DHP segment followed by a frame for each resolution, it
+// stops at the SOS marker of the first frame. The later frames can be
+// read by continuing to call Scanner.Scan, with Scanner.Frame telling
+// them apart.
 func ReadSegments(scanner *Scanner) ([]Segment, error) {
 	var segments = make([]Segment, 0, 20)
 	for {
@@ -517,7 +988,7 @@ func PutMPFHeader(buf []byte) uint32 {
 func GetMPFTree(buf []byte, space tiff.TagSpace) (*tiff.IFDNode, error) {
 	valid, order, ifdpos := tiff.GetHeader(buf)
 	if !valid {
-		return nil, errors.New("GetMPFTree: Invalid Tiff header")
+		return nil, &SyntaxError{-1, APP2, ErrMPFHeader}
 	}
 	node, err := tiff.GetIFDTree(buf, order, ifdpos, space)
 	if err != nil {
@@ -546,6 +1017,13 @@ func MakeMPFSegment(tree *tiff.IFDNode) ([]byte, error) {
 	return buf, nil
 }
 
+// MPFOffset returns the MPF relative offset, from which MPF positions
+// are measured, for an MPF APP2 segment at the location given by
+// 'offsets', as returned by Scanner.Offsets.
+func MPFOffset(offsets Offsets) uint32 {
+	return uint32(offsets.Data) + MPFHeaderSize
+}
+
 // MPFIndex holds the data from an MPF index segment about image
 // locations in a file.
 type MPFIndex struct {
@@ -576,10 +1054,10 @@ func MPFIndexFromTIFF(node *tiff.IFDNode, offset uint32) (*MPFIndex, error) {
 		}
 	}
 	if count == 0 {
-		return nil, errors.New("MPF image count is 0")
+		return nil, &SyntaxError{int64(offset), APP2, ErrMPFCount}
 	}
 	if uint32(len(entryField.Data)) < 16*count {
-		return nil, errors.New("MPF Entry doesn't have 16 bytes for each image")
+		return nil, &SyntaxError{int64(offset), APP2, ErrMPFEntry}
 	}
 	offsets := make([]uint32, count)
 	lengths := make([]uint32, count)
@@ -588,16 +1066,16 @@ func MPFIndexFromTIFF(node *tiff.IFDNode, offset uint32) (*MPFIndex, error) {
 		if relOffset != 0 {
 			offsets[i] = relOffset + offset
 			if offsets[i] < offset {
-				return nil, errors.New("MPF offset overflow")
+				return nil, &SyntaxError{int64(offset), APP2, ErrMPFOffsetOverflow}
 			}
 		}
 		if i == 0 {
 			if offsets[i] != 0 {
-				return nil, errors.New("First image should have an MPF offset of zero")
+				return nil, &SyntaxError{int64(offset), APP2, ErrMPFFirstOffset}
 			}
 		} else {
 			if offsets[i] == 0 {
-				return nil, errors.New("Only the first image should have an MPF offset of zero")
+				return nil, &SyntaxError{int64(offset), APP2, ErrMPFZeroOffset}
 			}
 		}
 		lengths[i] = entryField.Long(i*4+1, order)
@@ -646,14 +1124,14 @@ func (mpf *MPFIndex) PutToTiff(node *tiff.IFDNode) {
 // MPFProcessor is an interface that provides a function for
 // processing MPF APP2 blocks. It assumes that 'seg' is a slice
 // containing a JPEG APP2 data segment, as returned by Scanner.Scan,
-// and that 'reader' has just read that segment and is now positioned
-// one byte past its end. 'writer' is either nil if not required, or
+// and that 'offsets' gives its location, as returned by
+// Scanner.Offsets. 'writer' is either nil if not required, or
 // an output stream to which we can write an APP2 marker and data
 // segment. It returns a bool indicating whether an MPF block was
 // processed, the APP2 data segment, possibly modified, and an error
 // value.
 type MPFProcessor interface {
-	ProcessAPP2(writer io.WriteSeeker, reader io.ReadSeeker, seg []byte) (bool, []byte, error)
+	ProcessAPP2(writer io.WriteSeeker, offsets Offsets, seg []byte) (bool, []byte, error)
 }
 
 // MPFCheck conforms to the MPFProcessor interface. It checks for the
@@ -661,7 +1139,7 @@ type MPFProcessor interface {
 type MPFCheck struct {
 }
 
-func (MPFCheck) ProcessAPP2(_ io.WriteSeeker, _ io.ReadSeeker, seg []byte) (bool, []byte, error) {
+func (MPFCheck) ProcessAPP2(_ io.WriteSeeker, _ Offsets, seg []byte) (bool, []byte, error) {
 	isMPF, _ := GetMPFHeader(seg)
 	return isMPF, seg, nil
 }
@@ -673,23 +1151,14 @@ type MPFGetIndex struct {
 	Index *MPFIndex // MPF Index info.
 }
 
-func (mpfData *MPFGetIndex) ProcessAPP2(_ io.WriteSeeker, reader io.ReadSeeker, seg []byte) (bool, []byte, error) {
+func (mpfData *MPFGetIndex) ProcessAPP2(_ io.WriteSeeker, offsets Offsets, seg []byte) (bool, []byte, error) {
 	isMPF, next := GetMPFHeader(seg)
 	if isMPF {
 		tree, err := GetMPFTree(seg[next:], tiff.MPFIndexSpace)
 		if err != nil {
 			return false, nil, err
 		}
-		// MPF offsets are relative to the byte following the
-		// MPF header, which is 4 bytes past the start of seg.
-		// The current position of the reader is one byte past
-		// the data read into seg.
-		pos, err := reader.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return false, nil, err
-		}
-		offset := uint32(pos) - uint32(len(seg)-4)
-		if mpfData.Index, err = MPFIndexFromTIFF(tree, offset); err != nil {
+		if mpfData.Index, err = MPFIndexFromTIFF(tree, MPFOffset(offsets)); err != nil {
 			return false, nil, err
 		}
 	}
@@ -708,7 +1177,7 @@ type MPFIndexRewriter struct {
 	APP2WritePos uint32        // Position of the MPF APP2 marker in the output stream.
 }
 
-func (mpfData *MPFIndexRewriter) ProcessAPP2(writer io.WriteSeeker, reader io.ReadSeeker, seg []byte) (bool, []byte, error) {
+func (mpfData *MPFIndexRewriter) ProcessAPP2(writer io.WriteSeeker, offsets Offsets, seg []byte) (bool, []byte, error) {
 	isMPF, next := GetMPFHeader(seg)
 	if isMPF {
 		// copy the segment before decoding, since the tree
@@ -722,23 +1191,14 @@ func (mpfData *MPFIndexRewriter) ProcessAPP2(writer io.WriteSeeker, reader io.Re
 			return false, nil, err
 		}
 		mpfData.Tree.Fix()
-		// MPF offsets are relative to the byte following the
-		// MPF header, which is 4 bytes past the start of seg.
-		// The current position of the reader is one byte past
-		// the data read into seg.
-		pos, err := reader.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return false, nil, err
-		}
-		offset := uint32(pos) - uint32(len(seg)-4)
-		if mpfData.Index, err = MPFIndexFromTIFF(mpfData.Tree, offset); err != nil {
+		if mpfData.Index, err = MPFIndexFromTIFF(mpfData.Tree, MPFOffset(offsets)); err != nil {
 			return false, nil, err
 		}
 		seg, err = MakeMPFSegment(mpfData.Tree)
 		if err != nil {
 			return false, nil, err
 		}
-		pos, err = writer.Seek(0, io.SeekCurrent)
+		pos, err := writer.Seek(0, io.SeekCurrent)
 		if err != nil {
 			return false, nil, err
 		}
@@ -767,10 +1227,13 @@ func SetMPFPositions(mpfTree *tiff.IFDNode, mpfOffset uint32, offsets []uint32,
 // mpfWritePos. 'offsets' and 'end' as processed as per
 // SetMPFPositions.
 func RewriteMPF(writer io.WriteSeeker, mpfTree *tiff.IFDNode, mpfWritePos uint32, offsets []uint32, end uint32) error {
+	if len(offsets) == 0 {
+		return &SyntaxError{int64(mpfWritePos), APP2, ErrMPFCount}
+	}
 	SetMPFPositions(mpfTree, mpfWritePos+8, offsets, end)
 	seg, err := MakeMPFSegment(mpfTree)
 	if err != nil {
-		return err
+		return locate(err, int64(mpfWritePos), APP2)
 	}
 	if _, err := writer.Seek(int64(mpfWritePos), io.SeekStart); err != nil {
 		return err
@@ -779,7 +1242,7 @@ func RewriteMPF(writer io.WriteSeeker, mpfTree *tiff.IFDNode, mpfWritePos uint32
 		return err
 	}
 	if err := WriteData(writer, seg); err != nil {
-		return err
+		return locate(err, int64(mpfWritePos), APP2)
 	}
 	return nil
 }
@@ -0,0 +1,318 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// readFile reads a file from testdata.
+func readFile(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testImages returns the names of the JPEG files in testdata.
+func testImages(t testing.TB) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join("testdata", "*.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no test images found")
+	}
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	return names
+}
+
+// streamReader hides the Seek method of a reader.
+type streamReader struct {
+	io.Reader
+}
+
+// newTestScanner creates a scanner for an image, reading it either as
+// a file or as a stream.
+func newTestScanner(t testing.TB, data []byte, stream bool) *Scanner {
+	t.Helper()
+	var scanner *Scanner
+	var err error
+	if stream {
+		scanner, err = NewStreamScanner(streamReader{bytes.NewReader(data)})
+	} else {
+		scanner, err = NewScanner(bytes.NewReader(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+// scanSegments scans an image up to EOI, returning copies of the
+// segments.
+func scanSegments(scanner *Scanner) ([]Segment, error) {
+	var segments []Segment
+	for {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return segments, err
+		}
+		segments = append(segments, Segment{marker, append([]byte(nil), buf...)})
+		if marker == EOI {
+			return segments, nil
+		}
+	}
+}
+
+func TestStreamScanner(t *testing.T) {
+	for _, name := range testImages(t) {
+		data := readFile(t, name)
+		want, err := scanSegments(newTestScanner(t, data, false))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := scanSegments(newTestScanner(t, data, true))
+		if err != nil {
+			t.Fatalf("%s, stream: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: stream scanner returned different segments", name)
+		}
+		// A stream that returns one byte per read.
+		scanner, err := NewStreamScanner(iotest.OneByteReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = scanSegments(scanner)
+		if err != nil {
+			t.Fatalf("%s, one byte reads: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: stream scanner with one byte reads returned different segments", name)
+		}
+	}
+}
+
+// rawCopy copies an image with a Scanner and Dumper in raw mode.
+func rawCopy(t testing.TB, scanner *Scanner) []byte {
+	t.Helper()
+	scanner.SetRaw(true)
+	var buf bytes.Buffer
+	dumper, err := NewDumper(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumper.SetRaw(true)
+	for {
+		marker, data, err := scanner.Scan()
+		if err == io.EOF {
+			return buf.Bytes()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.DumpFill(scanner.Fill()); err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.Dump(marker, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	for _, name := range testImages(t) {
+		data := readFile(t, name)
+		for _, stream := range []bool{false, true} {
+			if out := rawCopy(t, newTestScanner(t, data, stream)); !bytes.Equal(out, data) {
+				t.Errorf("%s, stream %v: copy differs from the input", name, stream)
+			}
+		}
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	for _, name := range testImages(t) {
+		data := readFile(t, name)
+		for _, stream := range []bool{false, true} {
+			scanner := newTestScanner(t, data, stream)
+			scanner.SetRaw(true)
+			if offsets := scanner.Offsets(); offsets != (Offsets{0, -1, -1, 0}) {
+				t.Fatalf("%s: SOI offsets %+v", name, offsets)
+			}
+			end := int64(2) // End of the previous item.
+			for {
+				marker, buf, err := scanner.Scan()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				offsets := scanner.Offsets()
+				where := fmt.Sprintf("%s, stream %v, %s at %d", name, stream, marker.Name(), end)
+				if marker == 0 {
+					// Image data, or padding after EOI.
+					if offsets.Marker != -1 || offsets.Length != -1 || offsets.Data != end {
+						t.Fatalf("%s: offsets %+v", where, offsets)
+					}
+				} else {
+					if offsets.Marker != end+int64(scanner.Fill()) || !bytes.Equal(data[offsets.Marker:offsets.Marker+2], []byte{0xFF, byte(marker)}) {
+						t.Fatalf("%s: offsets %+v", where, offsets)
+					}
+					if buf == nil {
+						if offsets.Length != -1 || offsets.Data != -1 || offsets.Size != 0 {
+							t.Fatalf("%s: offsets %+v", where, offsets)
+						}
+						end = offsets.Marker + 2
+						continue
+					}
+					length := int64(data[offsets.Length])<<8 | int64(data[offsets.Length+1])
+					if offsets.Length != offsets.Marker+2 || offsets.Data != offsets.Length+2 || length != offsets.Size+2 {
+						t.Fatalf("%s: offsets %+v", where, offsets)
+					}
+				}
+				if offsets.Size != int64(len(buf)) || !bytes.Equal(data[offsets.Data:offsets.Data+offsets.Size], buf) {
+					t.Fatalf("%s: data doesn't match offsets %+v", where, offsets)
+				}
+				end = offsets.Data + offsets.Size
+			}
+			if end != int64(len(data)) {
+				t.Errorf("%s, stream %v: scanned %d bytes of %d", name, stream, end, len(data))
+			}
+		}
+	}
+}
+
+// insertBefore returns a copy of 'data' with 'insert' placed before the
+// first occurrence of 'marker'.
+func insertBefore(t testing.TB, data []byte, marker Marker, insert []byte) []byte {
+	t.Helper()
+	pos := bytes.Index(data, []byte{0xFF, byte(marker)})
+	if pos < 0 {
+		t.Fatalf("%s marker not found", marker.Name())
+	}
+	result := append([]byte(nil), data[:pos]...)
+	result = append(result, insert...)
+	return append(result, data[pos:]...)
+}
+
+// makeJPEG encodes a test image with image/jpeg, which writes a
+// baseline JPEG with 4:2:0 subsampling for colour images.
+func makeJPEG(t testing.TB, width, height int, gray bool) []byte {
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				g.Pix[y*g.Stride+x] = uint8(x*x + y*5)
+			}
+		}
+		img = g
+	} else {
+		c := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c.Set(x, y, color.RGBA{uint8(x * 7), uint8(y * 3), uint8(x*y + x), 255})
+			}
+		}
+		img = c
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLenient(t *testing.T) {
+	data := makeJPEG(t, 40, 24, false)
+	longFill := bytes.Repeat([]byte{0xFF}, 70000)
+	tests := []struct {
+		name    string
+		insert  []byte // Inserted before the DHT marker.
+		garbage []byte // Expected garbage, or nil if none.
+		fill    int    // Expected number of fill bytes before DHT.
+	}{
+		{"bytes before marker", []byte{1, 2, 0xFF, 0, 3}, []byte{1, 2, 0xFF, 0, 3}, 0},
+		{"fill bytes and zero", []byte{0xFF, 0xFF, 0, 7}, []byte{0xFF, 0xFF, 0, 7}, 0},
+		{"zero length", []byte{0xFF, byte(COM), 0, 0, 9}, []byte{0xFF, byte(COM), 0, 0, 9}, 0},
+		{"length one", []byte{0xFF, 0xFF, byte(APP0 + 5), 0, 1}, []byte{0xFF, 0xFF, byte(APP0 + 5), 0, 1}, 0},
+		{"long fill", longFill, nil, len(longFill)},
+		{"long fill and zero", append(longFill, 0), append(longFill, 0), 0},
+		{"long fill and length one", append(longFill, byte(APP0+5), 0, 1), append(longFill, byte(APP0+5), 0, 1), 0},
+	}
+	for _, test := range tests {
+		bad := insertBefore(t, data, DHT, test.insert)
+		for _, stream := range []bool{false, true} {
+			scanner := newTestScanner(t, bad, stream)
+			scanner.SetLenient(true)
+			var garbage [][]byte
+			fill := -1
+			for {
+				marker, buf, err := scanner.Scan()
+				if err != nil {
+					t.Fatalf("%s, stream %v: %v", test.name, stream, err)
+				}
+				switch marker {
+				case Garbage:
+					garbage = append(garbage, append([]byte(nil), buf...))
+				case DHT:
+					if fill < 0 {
+						fill = scanner.Fill()
+					}
+				}
+				if marker == EOI {
+					break
+				}
+			}
+			if test.garbage == nil && len(garbage) != 0 || test.garbage != nil && (len(garbage) != 1 || !bytes.Equal(garbage[0], test.garbage)) {
+				t.Errorf("%s, stream %v: garbage of %d segments, expected %d bytes", test.name, stream, len(garbage), len(test.garbage))
+			}
+			if fill != test.fill {
+				t.Errorf("%s, stream %v: %d fill bytes before DHT, expected %d", test.name, stream, fill, test.fill)
+			}
+			// In raw mode, the input is reproduced exactly.
+			scanner = newTestScanner(t, bad, stream)
+			scanner.SetLenient(true)
+			if out := rawCopy(t, scanner); !bytes.Equal(out, bad) {
+				t.Errorf("%s, stream %v: raw copy differs from the input", test.name, stream)
+			}
+		}
+	}
+}
+
+func TestLenientTruncated(t *testing.T) {
+	data := makeJPEG(t, 40, 24, false)
+	for size := 2; size < len(data); size++ {
+		scanner, err := NewScanner(bytes.NewReader(data[:size]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner.SetLenient(true)
+		segments, err := scanSegments(scanner)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if scanner.Offsets().Marker != -1 {
+			t.Fatalf("size %d: EOI wasn't synthesized", size)
+		}
+		if len(segments) == 0 {
+			t.Fatalf("size %d: no segments", size)
+		}
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 // Unpack a JPEG file one segment at a time and repackage into a new
 // JPEG file.  It can process files which use the Multi-Picture Format
-// extension to contain multiple images.
+// extension to contain multiple images. With the -optimize or
+// -progressive options, the image data of each image is reencoded,
+// either sequentially with optimized Huffman tables, or progressively.
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	jseg "github.com/garyhouston/jpegsegs"
 	tiff "github.com/garyhouston/tiff66"
 	"io"
 	"log"
 	"os"
+	"runtime"
 )
 
 // MPFAttributeData conforms to the jseg.MPFProcessor interface. It's
@@ -20,7 +25,7 @@ import (
 type MPFAttributeData struct {
 }
 
-func (mpfData *MPFAttributeData) ProcessAPP2(writer io.WriteSeeker, reader io.ReadSeeker, buf []byte) (bool, []byte, error) {
+func (mpfData *MPFAttributeData) ProcessAPP2(writer io.WriteSeeker, offsets jseg.Offsets, buf []byte) (bool, []byte, error) {
 	isMPF, next := jseg.GetMPFHeader(buf)
 	if isMPF {
 		tree, err := jseg.GetMPFTree(buf[next:], tiff.MPFAttributeSpace)
@@ -37,7 +42,7 @@ func (mpfData *MPFAttributeData) ProcessAPP2(writer io.WriteSeeker, reader io.Re
 }
 
 // Copy a single image, processing any MPF segment found.
-func copyImage(writer io.WriteSeeker, reader io.ReadSeeker, mpfProcessor jseg.MPFProcessor) error {
+func copyImage(writer io.WriteSeeker, reader io.ReadSeeker, mpfProcessor jseg.MPFProcessor, options *jseg.EncodeOptions) error {
 	scanner, err := jseg.NewScanner(reader)
 	if err != nil {
 		return err
@@ -46,13 +51,16 @@ func copyImage(writer io.WriteSeeker, reader io.ReadSeeker, mpfProcessor jseg.MP
 	if err != nil {
 		return err
 	}
+	if options != nil {
+		return reencodeImage(writer, scanner, dumper, mpfProcessor, options)
+	}
 	for {
 		marker, buf, err := scanner.Scan()
 		if err != nil {
 			return err
 		}
 		if marker == jseg.APP0+2 {
-			_, buf, err = mpfProcessor.ProcessAPP2(writer, reader, buf)
+			_, buf, err = mpfProcessor.ProcessAPP2(writer, scanner.Offsets(), buf)
 			if err != nil {
 				return err
 			}
@@ -67,10 +75,49 @@ func copyImage(writer io.WriteSeeker, reader io.ReadSeeker, mpfProcessor jseg.MP
 	return nil
 }
 
+// Copy a single image, decoding its image data and reencoding it with
+// the given options. Segments preceding the frame header are written
+// as soon as they are read, so that the MPF processor sees the current
+// output position.
+func reencodeImage(writer io.WriteSeeker, scanner *jseg.Scanner, dumper *jseg.Dumper, mpfProcessor jseg.MPFProcessor, options *jseg.EncodeOptions) error {
+	decoder := jseg.NewDecoder()
+	decoder.Workers = runtime.NumCPU()
+	written := 0
+	for !decoder.Done {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return err
+		}
+		if marker == jseg.APP0+2 && decoder.Coefficients == nil {
+			_, buf, err = mpfProcessor.ProcessAPP2(writer, scanner.Offsets(), buf)
+			if err != nil {
+				return err
+			}
+		}
+		if err := decoder.Process(marker, buf); err != nil {
+			return err
+		}
+		if decoder.Coefficients == nil {
+			if err := jseg.WriteSegments(dumper, decoder.Segments[written:]); err != nil {
+				return err
+			}
+			written = len(decoder.Segments)
+		}
+	}
+	if len(decoder.Samples) > 0 {
+		return errors.New("lossless images aren't supported")
+	}
+	if decoder.Coefficients == nil {
+		return errors.New("no image found")
+	}
+	return jseg.WriteCoefficients(dumper, decoder.Coefficients, decoder.Segments[written:], options)
+}
+
 // State for MPF image iterator.
 type copyData struct {
 	writer     io.WriteSeeker
 	newOffsets []uint32
+	options    *jseg.EncodeOptions
 }
 
 // Function to be applied to each MPF image: copies the image to the
@@ -83,41 +130,54 @@ func (copy *copyData) MPFApply(reader io.ReadSeeker, index uint32, length uint32
 		}
 		copy.newOffsets[index] = uint32(pos)
 		var mpfAttribute MPFAttributeData
-		return copyImage(copy.writer, reader, &mpfAttribute)
+		return copyImage(copy.writer, reader, &mpfAttribute, copy.options)
 	}
 	return nil
 }
 
 // Copy additional images specified with MPF.
-func copyMPFImages(writer io.WriteSeeker, reader io.ReadSeeker, index *jseg.MPFIndex) ([]uint32, error) {
+func copyMPFImages(writer io.WriteSeeker, reader io.ReadSeeker, index *jseg.MPFIndex, options *jseg.EncodeOptions) ([]uint32, error) {
 	var copy copyData
 	copy.writer = writer
+	copy.options = options
 	copy.newOffsets = make([]uint32, len(index.ImageOffsets))
 	index.ImageIterate(reader, &copy)
 	return copy.newOffsets, nil
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Printf("Usage: %s infile outfile\n", os.Args[0])
+	optimize := flag.Bool("optimize", false, "reencode image data sequentially with optimized Huffman tables")
+	progressive := flag.Bool("progressive", false, "reencode image data progressively")
+	arithmetic := flag.Bool("arithmetic", false, "reencode image data with arithmetic coding")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-optimize | -progressive] [-arithmetic] infile outfile\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
 		return
 	}
-	reader, err := os.Open(os.Args[1])
+	var options *jseg.EncodeOptions
+	if *optimize || *progressive || *arithmetic {
+		options = &jseg.EncodeOptions{OptimizeHuffman: *optimize, Progressive: *progressive, Arithmetic: *arithmetic}
+	}
+	reader, err := os.Open(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer reader.Close()
-	writer, err := os.Create(os.Args[2])
+	writer, err := os.Create(flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer writer.Close()
 	var mpfIndex jseg.MPFIndexRewriter
-	if err = copyImage(writer, reader, &mpfIndex); err != nil {
+	if err = copyImage(writer, reader, &mpfIndex, options); err != nil {
 		log.Fatal(err)
 	}
 	if mpfIndex.Tree != nil {
-		newOffsets, err := copyMPFImages(writer, reader, mpfIndex.Index)
+		newOffsets, err := copyMPFImages(writer, reader, mpfIndex.Index, options)
 		if err != nil {
 			log.Fatal(err)
 		}
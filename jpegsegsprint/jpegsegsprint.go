@@ -1,8 +1,12 @@
 package main
 
-// Print JPEG markers and segment lengths.
+// Print JPEG markers and segment lengths, each preceded by its file
+// offset, and a description of each scan. The markers of an Exif
+// thumbnail are printed after those of the image containing it, with
+// offsets relative to the start of the thumbnail.
 
 import (
+	"bytes"
 	"fmt"
 	jseg "github.com/garyhouston/jpegsegs"
 	"io"
@@ -16,15 +20,24 @@ func scanImage(reader io.ReadSeeker, mpfProcessor jseg.MPFProcessor) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("SOI")
+	fmt.Printf("%d: SOI\n", scanner.Offsets().Marker)
 	dataCount := uint32(0)
+	dataOffset := int64(0)
 	resetCount := uint32(0)
+	var frame *jseg.FrameHeader
+	var process jseg.CodingProcess
+	scanCount := 0
+	var thumbnail []byte
 	for {
 		marker, buf, err := scanner.Scan()
 		if err != nil {
 			return err
 		}
+		offsets := scanner.Offsets()
 		if marker == 0 {
+			if dataCount == 0 && resetCount == 0 {
+				dataOffset = offsets.Data
+			}
 			dataCount += uint32(len(buf))
 			continue
 		}
@@ -33,7 +46,7 @@ func scanImage(reader io.ReadSeeker, mpfProcessor jseg.MPFProcessor) error {
 			continue
 		}
 		if dataCount > 0 || resetCount > 0 {
-			fmt.Printf("%d bytes of image data", dataCount)
+			fmt.Printf("%d: %d bytes of image data", dataOffset, dataCount)
 			if resetCount > 0 {
 				fmt.Printf(" and %d reset markers", resetCount)
 			}
@@ -42,23 +55,75 @@ func scanImage(reader io.ReadSeeker, mpfProcessor jseg.MPFProcessor) error {
 			resetCount = 0
 		}
 		if buf == nil {
-			fmt.Println(marker.Name())
+			fmt.Printf("%d: %s\n", offsets.Marker, marker.Name())
 			if marker == jseg.EOI {
+				if thumbnail != nil {
+					fmt.Printf("Exif thumbnail, %d bytes:\n", len(thumbnail))
+					// A bad thumbnail doesn't stop the
+					// listing of any further images.
+					if err := scanImage(bytes.NewReader(thumbnail), &jseg.MPFCheck{}); err != nil {
+						fmt.Printf("Exif thumbnail: %v\n", err)
+					}
+				}
 				return nil
 			}
 			continue
 		}
+		if marker.IsSOF() {
+			if frame, err = jseg.GetFrameHeader(buf); err != nil {
+				return err
+			}
+			process = marker.Process()
+			scanCount = 0
+			fmt.Printf("%d: %s, %d bytes, frame %d, %dx%d, %s\n", offsets.Marker, marker.Name(), len(buf), scanner.Frame(), frame.Width, frame.Height, process)
+			continue
+		}
+		if marker == jseg.DHP {
+			hierarchy, err := jseg.GetHierarchyHeader(buf)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d: %s, %d bytes, hierarchical %dx%d\n", offsets.Marker, marker.Name(), len(buf), hierarchy.Width, hierarchy.Height)
+			continue
+		}
+		if marker == jseg.EXP {
+			exp, err := jseg.GetExpansion(buf)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d: %s, %d bytes, expand horizontally %v, vertically %v\n", offsets.Marker, marker.Name(), len(buf), exp.Horizontal, exp.Vertical)
+			continue
+		}
+		if marker == jseg.SOS && frame != nil {
+			scan, err := jseg.GetScanHeader(buf)
+			if err != nil {
+				return err
+			}
+			scanCount++
+			fmt.Printf("%d: %s, %d bytes, scan %d: %s\n", offsets.Marker, marker.Name(), len(buf), scanCount, scan.Describe(frame, process))
+			continue
+		}
+		if marker == jseg.APP1 {
+			// An Exif segment that can't be decoded is
+			// printed like any other segment.
+			var exif jseg.ExifGetTree
+			if done, _, err := exif.ProcessAPP1(buf); done && err == nil {
+				fmt.Printf("%d: %s, %d bytes (Exif segment)\n", offsets.Marker, marker.Name(), len(buf))
+				thumbnail = jseg.ExifThumbnail(exif.Tree)
+				continue
+			}
+		}
 		if marker == jseg.APP0+2 {
-			done, buf, err := mpfProcessor.ProcessAPP2(nil, reader, buf)
+			done, buf, err := mpfProcessor.ProcessAPP2(nil, offsets, buf)
 			if err != nil {
 				return err
 			}
 			if done {
-				fmt.Printf("%s, %d bytes (MPF segment)\n", marker.Name(), len(buf))
+				fmt.Printf("%d: %s, %d bytes (MPF segment)\n", offsets.Marker, marker.Name(), len(buf))
 				continue
 			}
 		}
-		fmt.Printf("%s, %d bytes\n", marker.Name(), len(buf))
+		fmt.Printf("%d: %s, %d bytes\n", offsets.Marker, marker.Name(), len(buf))
 	}
 }
 
@@ -0,0 +1,240 @@
+package main
+
+// Losslessly rotate or mirror the first image in a JPEG file, by
+// transforming its DCT coefficients. The Exif Orientation tag is reset
+// to 1 and the Exif pixel dimensions are updated. Other segments and
+// any additional images encoded with Multi-Picture Format (MPF) are
+// copied unchanged. With the auto transform, a file that doesn't need
+// correcting is copied unchanged.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	jseg "github.com/garyhouston/jpegsegs"
+	tiff "github.com/garyhouston/tiff66"
+	"io"
+	"log"
+	"os"
+	"runtime"
+)
+
+// Read the coefficients and segments of the first image, and the MPF
+// index if present.
+func readImage(reader io.ReadSeeker) (*jseg.Coefficients, []jseg.Segment, *jseg.MPFIndex, error) {
+	scanner, err := jseg.NewScanner(reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	decoder := jseg.NewDecoder()
+	decoder.Workers = runtime.NumCPU()
+	var mpfIndex jseg.MPFGetIndex
+	for !decoder.Done {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if marker == jseg.APP2 {
+			if _, _, err := mpfIndex.ProcessAPP2(nil, scanner.Offsets(), buf); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if err := decoder.Process(marker, buf); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if len(decoder.Samples) > 0 {
+		return nil, nil, nil, errors.New("lossless images aren't supported")
+	}
+	if decoder.Coefficients == nil {
+		return nil, nil, nil, errors.New("no image found")
+	}
+	return decoder.Coefficients, decoder.Segments, mpfIndex.Index, nil
+}
+
+// Write the transformed image. If there's an MPF segment, returns its
+// TIFF tree and the position of its APP2 marker in the output.
+func writeImage(writer io.WriteSeeker, coeffs *jseg.Coefficients, segments []jseg.Segment) (*tiff.IFDNode, uint32, error) {
+	dumper, err := jseg.NewDumper(writer)
+	if err != nil {
+		return nil, 0, err
+	}
+	var mpfTree *tiff.IFDNode
+	var mpfPos uint32
+	for _, seg := range segments {
+		if seg.Marker == jseg.APP2 {
+			if isMPF, next := jseg.GetMPFHeader(seg.Data); isMPF {
+				if mpfTree, err = jseg.GetMPFTree(seg.Data[next:], tiff.MPFIndexSpace); err != nil {
+					return nil, 0, err
+				}
+				mpfTree.Fix()
+				if seg.Data, err = jseg.MakeMPFSegment(mpfTree); err != nil {
+					return nil, 0, err
+				}
+				pos, err := writer.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, 0, err
+				}
+				mpfPos = uint32(pos)
+			}
+		}
+		if err := dumper.Dump(seg.Marker, seg.Data); err != nil {
+			return nil, 0, err
+		}
+	}
+	// Encode as in the input. The input's Huffman tables are reused
+	// unless the transform leaves them unable to code the image.
+	process := coeffs.Marker.Process()
+	options := jseg.EncodeOptions{Progressive: process.Progressive, Arithmetic: process.Arithmetic}
+	if process.Progressive {
+		options.Scans = coeffs.Scans
+	}
+	return mpfTree, mpfPos, jseg.WriteCoefficients(dumper, coeffs, nil, &options)
+}
+
+// Find the transform that corrects the Exif Orientation, or
+// TransformNone if there's no Orientation tag.
+func exifTransform(segments []jseg.Segment) jseg.Transform {
+	for _, seg := range segments {
+		if seg.Marker == jseg.APP1 {
+			var exif jseg.ExifGetTree
+			if isExif, _, err := exif.ProcessAPP1(seg.Data); isExif && err == nil {
+				if orientation := jseg.ExifOrientation(exif.Tree); orientation != 0 {
+					return jseg.OrientationTransform(orientation)
+				}
+			}
+		}
+	}
+	return jseg.TransformNone
+}
+
+// Reset the Exif Orientation to 1 and set the Exif pixel dimensions to
+// those of the transformed frame.
+func updateExif(segments []jseg.Segment, frame *jseg.FrameHeader) error {
+	exif := jseg.ExifRewriter{Edit: func(tree *tiff.IFDNode) error {
+		jseg.SetExifOrientation(tree, 1)
+		jseg.SetExifDimensions(tree, uint32(frame.Width), uint32(frame.Height))
+		return nil
+	}}
+	for i, seg := range segments {
+		if seg.Marker == jseg.APP1 {
+			_, buf, err := exif.ProcessAPP1(seg.Data)
+			if err != nil {
+				return err
+			}
+			segments[i].Data = buf
+		}
+	}
+	return nil
+}
+
+// Copy the input file unchanged, when no transform is needed.
+func copyFile(reader io.ReadSeeker, name string) error {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	writer, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// State for MPF image iterator.
+type copyData struct {
+	writer     io.WriteSeeker
+	newOffsets []uint32
+}
+
+// Function to be applied to each MPF image: copies the image to the
+// output stream without modification.
+func (copy *copyData) MPFApply(reader io.ReadSeeker, index uint32, length uint32) error {
+	if index > 0 {
+		pos, err := copy.writer.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		copy.newOffsets[index] = uint32(pos)
+		_, err = io.CopyN(copy.writer, reader, int64(length))
+		return err
+	}
+	return nil
+}
+
+// Find a transform by name.
+func parseTransform(name string) (jseg.Transform, bool) {
+	for t := jseg.TransformNone; t <= jseg.Rotate270; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return jseg.TransformNone, false
+}
+
+func main() {
+	trim := flag.Bool("trim", false, "drop partial iMCUs at edges that can't be transformed")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-trim] transform infile outfile\n", os.Args[0])
+		fmt.Println("transform is one of fliph, flipv, rotate90, rotate180, rotate270,")
+		fmt.Println("transpose, transverse, or auto to correct the Exif Orientation.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 3 {
+		flag.Usage()
+		return
+	}
+	auto := flag.Arg(0) == "auto"
+	transform, ok := parseTransform(flag.Arg(0))
+	if !ok && !auto {
+		log.Fatalf("unknown transform %s", flag.Arg(0))
+	}
+	reader, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+	coeffs, segments, mpfIndex, err := readImage(reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auto {
+		transform = exifTransform(segments)
+		if transform == jseg.TransformNone {
+			if err := copyFile(reader, flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	coeffs = coeffs.Transform(transform, *trim)
+	if err := updateExif(segments, &coeffs.Frame); err != nil {
+		log.Fatal(err)
+	}
+	writer, err := os.Create(flag.Arg(2))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer writer.Close()
+	mpfTree, mpfPos, err := writeImage(writer, coeffs, segments)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mpfTree != nil && mpfIndex != nil {
+		copy := copyData{writer: writer, newOffsets: make([]uint32, len(mpfIndex.ImageOffsets))}
+		if err := mpfIndex.ImageIterate(reader, &copy); err != nil {
+			log.Fatal(err)
+		}
+		end, err := writer.Seek(0, io.SeekCurrent)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = jseg.RewriteMPF(writer, mpfTree, mpfPos, copy.newOffsets, uint32(end)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package jpegsegs
+
+// Decoding of lossless (predictive) frames, as used by SOF3 and, in
+// hierarchical images, SOF7.
+
+// ComponentSamples holds the samples of an image component in a
+// lossless frame.
+type ComponentSamples struct {
+	FrameComponent
+	Width   int      // Number of samples per row.
+	Height  int      // Number of sample rows.
+	Stride  int      // Number of samples per row in Samples, padded to whole MCUs.
+	Rows    int      // Number of sample rows in Samples, padded to whole MCUs.
+	Samples []uint16 // Stride * Rows samples in row-major order.
+}
+
+// Sample returns the sample at column x, row y.
+func (comp *ComponentSamples) Sample(x, y int) *uint16 {
+	return &comp.Samples[y*comp.Stride+x]
+}
+
+// Samples holds the decoded samples of a lossless frame. The samples
+// have the full precision of the frame, i.e., the point transform has
+// been reversed. In a differential frame of a hierarchical image, they
+// are the differences, modulo 2^16, from the upsampled reference
+// frame.
+type Samples struct {
+	Marker     Marker // SOFn marker of the frame.
+	Frame      FrameHeader
+	Components []ComponentSamples // In the same order as Frame.Components.
+	MCUsWide   int                // Number of MCUs per row in interleaved scans.
+	MCUsHigh   int                // Number of MCU rows in interleaved scans.
+	Scans      []ScanHeader       // Scan headers, in the order decoded.
+}
+
+// NewSamples allocates zeroed samples for a lossless frame.
+func NewSamples(marker Marker, frame *FrameHeader) *Samples {
+	samples := &Samples{Marker: marker, Frame: *frame}
+	samples.Frame.Components = append([]FrameComponent(nil), frame.Components...)
+	hmax, vmax := frame.MaxSampling()
+	width, height := int(frame.Width), int(frame.Height)
+	samples.MCUsWide = (width + hmax - 1) / hmax
+	samples.MCUsHigh = (height + vmax - 1) / vmax
+	samples.Components = make([]ComponentSamples, len(frame.Components))
+	for i, fc := range frame.Components {
+		comp := &samples.Components[i]
+		comp.FrameComponent = fc
+		comp.Width = (width*int(fc.H) + hmax - 1) / hmax
+		comp.Height = (height*int(fc.V) + vmax - 1) / vmax
+		comp.Stride = samples.MCUsWide * int(fc.H)
+		comp.Rows = samples.MCUsHigh * int(fc.V)
+		comp.Samples = make([]uint16, comp.Stride*comp.Rows)
+	}
+	return samples
+}
+
+// mcuSamples calls 'f' for each sample in MCU 'mcu' of a scan, with
+// the index of the scan component and the sample's position.
+func (samples *Samples) mcuSamples(comps []int, mcu int, f func(i, x, y int) error) error {
+	if len(comps) == 1 {
+		comp := &samples.Components[comps[0]]
+		return f(0, mcu%comp.Width, mcu/comp.Width)
+	}
+	mcuX := mcu % samples.MCUsWide
+	mcuY := mcu / samples.MCUsWide
+	for i, ci := range comps {
+		comp := &samples.Components[ci]
+		for v := 0; v < int(comp.V); v++ {
+			for h := 0; h < int(comp.H); h++ {
+				if err := f(i, mcuX*int(comp.H)+h, mcuY*int(comp.V)+v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanMCUs returns the number of MCUs in a scan of the given frame
+// components.
+func (samples *Samples) scanMCUs(comps []int) int {
+	if len(comps) == 1 {
+		comp := &samples.Components[comps[0]]
+		return comp.Width * comp.Height
+	}
+	return samples.MCUsWide * samples.MCUsHigh
+}
+
+// rowMCUs returns the number of MCUs in each row of a scan of the
+// given frame components.
+func (samples *Samples) rowMCUs(comps []int) int {
+	if len(comps) == 1 {
+		return samples.Components[comps[0]].Width
+	}
+	return samples.MCUsWide
+}
+
+// predict returns the prediction for the sample at column x, row y of
+// a component, using predictor 1-7. 'top' is the first row of the
+// restart interval, which is predicted from the sample to its left,
+// and 'initial' is the prediction for its first sample. Samples in the
+// first column are predicted from the sample above. 'pt' is the point
+// transform.
+func (comp *ComponentSamples) predict(x, y, top int, predictor uint8, pt uint, initial int32) int32 {
+	switch {
+	case y == top && x == 0:
+		return initial
+	case y == top:
+		return int32(*comp.Sample(x-1, y) >> pt)
+	case x == 0:
+		return int32(*comp.Sample(x, y-1) >> pt)
+	}
+	ra := int32(*comp.Sample(x-1, y) >> pt)
+	rb := int32(*comp.Sample(x, y-1) >> pt)
+	rc := int32(*comp.Sample(x-1, y-1) >> pt)
+	switch predictor {
+	case 1:
+		return ra
+	case 2:
+		return rb
+	case 3:
+		return rc
+	case 4:
+		return ra + rb - rc
+	case 5:
+		return ra + (rb-rc)>>1
+	case 6:
+		return rb + (ra-rc)>>1
+	default:
+		return (ra + rb) >> 1
+	}
+}
+
+// decodeInterval decodes 'count' MCUs starting at MCU 'first' from
+// Huffman-coded data, which must start at the beginning of a restart
+// interval, and at the start of a row of MCUs. Returns the number of
+// whole bytes of data left over, or a negative number if the data was
+// too short.
+func (samples *Samples) decodeInterval(state *scanState, data []byte, first, count int) (int, error) {
+	br := bitReader{data: data}
+	scan := state.header
+	pt := uint(scan.Al)
+	differential := samples.Marker.Process().Differential
+	initial := int32(1) << (uint(samples.Frame.Precision) - pt - 1)
+	top := make([]int, len(state.comps))
+	for i, ci := range state.comps {
+		if len(state.comps) == 1 {
+			top[i] = first / samples.Components[ci].Width
+		} else {
+			top[i] = first / samples.MCUsWide * int(samples.Components[ci].V)
+		}
+	}
+	for mcu := first; mcu < first+count; mcu++ {
+		err := samples.mcuSamples(state.comps, mcu, func(i, x, y int) error {
+			t, err := br.decode(state.dc[i])
+			if err != nil {
+				return err
+			}
+			var diff int32
+			switch {
+			case t < 16:
+				diff = br.receiveExtend(uint(t))
+			case t == 16:
+				diff = 32768
+			default:
+				return newSyntaxError(ErrHuffmanCode)
+			}
+			comp := &samples.Components[state.comps[i]]
+			if !differential {
+				diff += comp.predict(x, y, top[i], scan.Ss, pt, initial)
+			}
+			*comp.Sample(x, y) = uint16(diff) << pt
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return br.unused(), nil
+}
+
+// startLosslessScan processes a scan header in a lossless frame.
+func (dec *Decoder) startLosslessScan(buf []byte) error {
+	samples := dec.lossless
+	header, err := GetScanHeader(buf)
+	if err != nil {
+		return err
+	}
+	comps, err := samples.Frame.scanComponents(header)
+	if err != nil {
+		return err
+	}
+	differential := samples.Marker.Process().Differential
+	if header.Ss > 7 || (header.Ss == 0) != differential || header.Se != 0 || header.Ah != 0 || header.Al >= samples.Frame.Precision {
+		return &SyntaxError{-1, SOS, ErrScanHeader}
+	}
+	state := &scanState{header: header, comps: comps, mcus: samples.scanMCUs(comps), samples: samples}
+	state.dc = make([]*huffDecoder, len(comps))
+	for i, sc := range header.Components {
+		state.dc[i] = dec.dc[sc.Td]
+		if state.dc[i] == nil {
+			return &SyntaxError{-1, SOS, ErrScanComponent}
+		}
+	}
+	// Intervals that start part way through a row would need the
+	// samples decoded by the previous interval.
+	if dec.restartInterval%samples.rowMCUs(comps) != 0 {
+		return &SyntaxError{-1, SOS, ErrUnsupported}
+	}
+	samples.Scans = append(samples.Scans, *header)
+	dec.scan = state
+	return nil
+}
+
+// ReadSamples reads a JPEG image from a scanner, up to and including
+// the EOI marker, and decodes the image data of its lossless frames,
+// which must use Huffman coding. Returns the frames in the order
+// decoded, which for a hierarchical image is from the lowest
+// resolution to the highest, and the segments that don't contain
+// tables or frame and scan headers, such as APPn and COM segments.
+func ReadSamples(scanner *Scanner) ([]*Samples, []Segment, error) {
+	dec := NewDecoder()
+	if err := dec.Decode(scanner); err != nil {
+		return nil, nil, err
+	}
+	if len(dec.Samples) == 0 {
+		return nil, nil, newSyntaxError(ErrUnsupported)
+	}
+	return dec.Samples, dec.Segments, nil
+}
@@ -0,0 +1,207 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// losslessFixture is a hand-made 4x2 8-bit grayscale image using
+// predictor 1 and a Huffman table with 3-bit codes for categories 0
+// to 5. The differences coded are -28 (from the initial prediction of
+// 128), 2, -1, 4 in the first row and -2 (from the sample above), 0,
+// 1, 11 in the second.
+var losslessFixture = []byte{
+	0xFF, 0xD8, // SOI
+	0xFF, 0xC4, 0x00, 0x19, 0x00, // DHT, DC table 0
+	0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 1, 2, 3, 4, 5,
+	0xFF, 0xC3, 0x00, 0x0B, 8, 0x00, 0x02, 0x00, 0x04, 1, 1, 0x11, 0, // SOF3
+	0xFF, 0xDA, 0x00, 0x08, 1, 1, 0x00, 1, 0, 0, // SOS, predictor 1
+	0xA3, 0x51, 0x38, 0x90, 0x72, 0xFF, 0x00, // Image data
+	0xFF, 0xD9, // EOI
+}
+
+// losslessFixtureSamples are the samples of losslessFixture.
+var losslessFixtureSamples = [][]uint16{
+	{100, 102, 101, 105},
+	{98, 98, 99, 110},
+}
+
+func TestLosslessFixture(t *testing.T) {
+	scanner, err := NewScanner(bytes.NewReader(losslessFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, _, err := ReadSamples(scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := &frames[0].Components[0]
+	if frames[0].Marker != SOF3 || comp.Width != 4 || comp.Height != 2 {
+		t.Fatalf("%s frame with %dx%d samples", frames[0].Marker.Name(), comp.Width, comp.Height)
+	}
+	for y, row := range losslessFixtureSamples {
+		for x, sample := range row {
+			if *comp.Sample(x, y) != sample {
+				t.Errorf("sample %d,%d is %d, expected %d", x, y, *comp.Sample(x, y), sample)
+			}
+		}
+	}
+	if _, _, err := ReadCoefficients(mustScanner(t, losslessFixture)); err == nil {
+		t.Error("ReadCoefficients accepted a lossless image")
+	}
+}
+
+// mustScanner creates a scanner for an image.
+func mustScanner(t *testing.T, data []byte) *Scanner {
+	t.Helper()
+	scanner, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+// losslessOptions describes an image to be written by
+// encodeLossless.
+type losslessOptions struct {
+	precision   int
+	pt          uint // Point transform.
+	predictor   int
+	interleaved bool // Code all components in one scan.
+	restart     int  // Restart interval in MCUs, or 0.
+}
+
+// encodeLossless writes a lossless image with the given samples,
+// which must be padded to whole MCUs and have the point transform
+// applied, using a Huffman table with 5-bit codes for all
+// categories.
+func encodeLossless(t *testing.T, samples *Samples, options losslessOptions) []byte {
+	var table HuffmanTable
+	table.Counts[4] = 17
+	for i := 0; i < 17; i++ {
+		table.Values = append(table.Values, uint8(i))
+	}
+	encoder := newHuffEncoder(&table)
+	var buf bytes.Buffer
+	dumper, _ := NewDumper(&buf)
+	dumper.Dump(DHT, MakeHuffmanSegment([]HuffmanTable{table}))
+	if options.restart > 0 {
+		dumper.Dump(DRI, []byte{byte(options.restart >> 8), byte(options.restart)})
+	}
+	dumper.Dump(SOF3, MakeFrameSegment(&samples.Frame))
+	var scans [][]int
+	if options.interleaved {
+		scans = [][]int{{}}
+		for i := range samples.Components {
+			scans[0] = append(scans[0], i)
+		}
+	} else {
+		for i := range samples.Components {
+			scans = append(scans, []int{i})
+		}
+	}
+	initial := int32(1) << (uint(options.precision) - options.pt - 1)
+	for _, comps := range scans {
+		header := ScanHeader{Ss: uint8(options.predictor), Al: uint8(options.pt)}
+		for _, ci := range comps {
+			header.Components = append(header.Components, ScanComponent{ID: samples.Components[ci].ID})
+		}
+		mcus := samples.scanMCUs(comps)
+		interval := options.restart
+		if interval == 0 {
+			interval = mcus
+		}
+		var intervals [][]byte
+		for first := 0; first < mcus; first += interval {
+			var bw bitWriter
+			for mcu := first; mcu < first+interval && mcu < mcus; mcu++ {
+				samples.mcuSamples(comps, mcu, func(i, x, y int) error {
+					comp := &samples.Components[comps[i]]
+					top := first / samples.rowMCUs(comps)
+					if len(comps) > 1 {
+						top *= int(comp.V)
+					}
+					prediction := comp.predict(x, y, top, uint8(options.predictor), options.pt, initial)
+					diff := int32(int16(uint16(int32(*comp.Sample(x, y)>>options.pt) - prediction)))
+					if diff == -32768 {
+						return bw.encode(encoder, 16)
+					}
+					size := category(diff)
+					if err := bw.encode(encoder, uint8(size)); err != nil {
+						return err
+					}
+					bw.writeValue(diff, size)
+					return nil
+				})
+			}
+			bw.flush()
+			intervals = append(intervals, bw.buf)
+		}
+		if err := writeScan(dumper, &header, intervals); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dumper.Dump(EOI, nil)
+	return buf.Bytes()
+}
+
+func TestLosslessRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	const width, height = 37, 21
+	gray := []FrameComponent{{ID: 1, H: 1, V: 1}}
+	color := []FrameComponent{{ID: 1, H: 2, V: 2}, {ID: 2, H: 1, V: 1}, {ID: 3, H: 1, V: 2}}
+	var tests []losslessOptions
+	for _, precision := range []int{2, 8, 12, 16} {
+		for predictor := 1; predictor <= 7; predictor++ {
+			tests = append(tests,
+				losslessOptions{precision, 0, predictor, true, 0},
+				losslessOptions{precision, 1, predictor, true, 2},
+				losslessOptions{precision, 0, predictor, false, 0})
+		}
+	}
+	for _, test := range tests {
+		for _, components := range [][]FrameComponent{gray, color} {
+			frame := FrameHeader{Precision: uint8(test.precision), Width: width, Height: height, Components: components}
+			samples := NewSamples(SOF3, &frame)
+			for i := range samples.Components {
+				comp := &samples.Components[i]
+				for j := range comp.Samples {
+					value := uint16(random.Intn(1 << uint(test.precision)))
+					if random.Intn(3) > 0 {
+						// Mostly smooth, to give small differences.
+						value = uint16(j*37) & (1<<uint(test.precision) - 1)
+					}
+					comp.Samples[j] = value >> test.pt << test.pt
+				}
+			}
+			options := test
+			// Restart intervals must be whole rows of MCUs.
+			options.restart *= samples.rowMCUs([]int{0, 1, 2}[:len(components)])
+			data := encodeLossless(t, samples, options)
+			name := fmt.Sprintf("%+v, %d components", options, len(components))
+			for _, workers := range []int{0, 4} {
+				dec, err := decodeWorkers(data, workers)
+				if err != nil {
+					t.Fatalf("%s, %d workers: %v", name, workers, err)
+				}
+				decoded := dec.Samples[0]
+				for i := range samples.Components {
+					want, got := &samples.Components[i], &decoded.Components[i]
+					if got.Width != want.Width || got.Height != want.Height {
+						t.Fatalf("%s: component %d has %dx%d samples, expected %dx%d", name, i, got.Width, got.Height, want.Width, want.Height)
+					}
+					for y := 0; y < want.Height; y++ {
+						for x := 0; x < want.Width; x++ {
+							if *got.Sample(x, y) != *want.Sample(x, y) {
+								t.Fatalf("%s: component %d, sample %d,%d is %d, expected %d", name, i, x, y, *got.Sample(x, y), *want.Sample(x, y))
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
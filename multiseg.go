@@ -0,0 +1,333 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Support for payloads that are too large for a single segment and
+// are split over multiple segments, each starting with a header that
+// identifies the payload type.
+
+// MaxDataSize is the maximum size of the data in a segment, excluding
+// the marker and length field.
+const MaxDataSize = 2<<15 - 3
+
+// ICCHeader is the text marker for an ICC profile chunk, found in a
+// JPEG APP2 segment. It's followed by a one byte sequence number,
+// starting at 1, and a one byte count of chunks.
+var ICCHeader = []byte("ICC_PROFILE\000")
+
+// ICCHeaderSize is the size of an ICC profile chunk header, including
+// the sequence number and count.
+const ICCHeaderSize = 14
+
+// ExtendedXMPHeader is the text marker for an Extended XMP chunk,
+// found in a JPEG APP1 segment. It's followed by a 32 byte GUID, the
+// four byte length of the full Extended XMP data, and the four byte
+// offset of the chunk within that data.
+var ExtendedXMPHeader = []byte("http://ns.adobe.com/xmp/extension/\000")
+
+// ExtendedXMPHeaderSize is the size of an Extended XMP chunk header,
+// including the GUID, length and offset.
+const ExtendedXMPHeaderSize = 35 + 32 + 4 + 4
+
+// PhotoshopHeader is the text marker for Photoshop image resource
+// data, found in a JPEG APP13 segment. Larger data is split over
+// multiple segments, each with the same header.
+var PhotoshopHeader = []byte("Photoshop 3.0\000")
+
+// PhotoshopHeaderSize is the size of a Photoshop header.
+const PhotoshopHeaderSize = 14
+
+// MakeICCSegments splits an ICC profile into a sequence of APP2
+// segments.
+func MakeICCSegments(profile []byte) ([]Segment, error) {
+	chunkSize := MaxDataSize - ICCHeaderSize
+	count := (len(profile) + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1
+	}
+	if count > 255 {
+		return nil, ErrICCTooLong
+	}
+	segments := make([]Segment, count)
+	for i := range segments {
+		chunk := profile[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		buf := make([]byte, ICCHeaderSize+len(chunk))
+		copy(buf, ICCHeader)
+		buf[ICCHeaderSize-2] = byte(i + 1)
+		buf[ICCHeaderSize-1] = byte(count)
+		copy(buf[ICCHeaderSize:], chunk)
+		segments[i] = Segment{APP2, buf}
+	}
+	return segments, nil
+}
+
+// isICCSegment checks if a segment contains an ICC profile chunk.
+func isICCSegment(segment Segment) bool {
+	return segment.Marker == APP2 && len(segment.Data) >= ICCHeaderSize && bytes.Equal(segment.Data[:len(ICCHeader)], ICCHeader)
+}
+
+// GetICCProfile reassembles an ICC profile from the APP2 segments in
+// a list of segments, ordering the chunks by their sequence numbers.
+// Returns nil if there's no profile, or ErrICCSequence if the chunks
+// don't all have the same count or their sequence numbers aren't
+// exactly 1 to the count. The profile itself can be checked with
+// CheckICCProfile.
+func GetICCProfile(segments []Segment) ([]byte, error) {
+	var chunks [][]byte
+	count := 0
+	for _, segment := range segments {
+		if !isICCSegment(segment) {
+			continue
+		}
+		seq := int(segment.Data[ICCHeaderSize-2])
+		if chunks == nil {
+			count = int(segment.Data[ICCHeaderSize-1])
+			chunks = make([][]byte, count)
+		}
+		if int(segment.Data[ICCHeaderSize-1]) != count || seq < 1 || seq > count || chunks[seq-1] != nil {
+			return nil, &SyntaxError{-1, APP2, ErrICCSequence}
+		}
+		chunks[seq-1] = segment.Data[ICCHeaderSize:]
+	}
+	if chunks == nil {
+		return nil, nil
+	}
+	var profile []byte
+	for _, chunk := range chunks {
+		if chunk == nil {
+			return nil, &SyntaxError{-1, APP2, ErrICCSequence}
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile, nil
+}
+
+// CheckICCProfile checks that an ICC profile has a valid header: the
+// profile size in the first 4 bytes must match its length, and the
+// signature at offset 36 must be "acsp".
+func CheckICCProfile(profile []byte) error {
+	if len(profile) < 128 || binary.BigEndian.Uint32(profile) != uint32(len(profile)) || string(profile[36:40]) != "acsp" {
+		return &SyntaxError{-1, APP2, ErrICCProfile}
+	}
+	return nil
+}
+
+// SetICCProfile returns a copy of a list of segments with the ICC
+// profile chunks replaced by a new profile, split as per
+// MakeICCSegments. The new segments take the place of the first old
+// chunk, or if there was none, follow any APP0, APP1 and APP2 segments
+// at the start of the list. Either way they're placed before any MPF
+// segment: MPF offsets are relative to the MPF segment, so a change in
+// the size of the segments following it would invalidate them. If
+// 'profile' is nil, the chunks are removed. Other APP2 segments,
+// including MPF, are kept.
+func SetICCProfile(segments []Segment, profile []byte) ([]Segment, error) {
+	var replacement []Segment
+	if profile != nil {
+		var err error
+		if replacement, err = MakeICCSegments(profile); err != nil {
+			return nil, err
+		}
+	}
+	mpf := len(segments)
+	for i, segment := range segments {
+		if isMPFSegment(segment) {
+			mpf = i
+			break
+		}
+	}
+	result := replaceSegments(segments[:mpf], isICCSegment, replacement)
+	return append(result, replaceSegments(segments[mpf:], isICCSegment, nil)...), nil
+}
+
+// isMPFSegment checks if a segment is an MPF APP2 segment.
+func isMPFSegment(segment Segment) bool {
+	isMPF, _ := GetMPFHeader(segment.Data)
+	return segment.Marker == APP2 && isMPF
+}
+
+// ExtendedXMPGUID returns the GUID that identifies Extended XMP data:
+// the MD5 digest of the data as 32 upper-case hexadecimal digits.
+func ExtendedXMPGUID(data []byte) string {
+	digest := md5.Sum(data)
+	return strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+// MakeExtendedXMPSegments splits Extended XMP data into a sequence of
+// APP1 segments, each labelled with 'guid', as returned by
+// ExtendedXMPGUID.
+func MakeExtendedXMPSegments(guid string, data []byte) ([]Segment, error) {
+	if len(guid) != 32 {
+		return nil, ErrXMPGUID
+	}
+	if uint64(len(data)) > 0xFFFFFFFF {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, len(data))
+	}
+	chunkSize := MaxDataSize - ExtendedXMPHeaderSize
+	var segments []Segment
+	for offset := 0; offset < len(data); offset += chunkSize {
+		chunk := data[offset:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		buf := make([]byte, ExtendedXMPHeaderSize+len(chunk))
+		next := copy(buf, ExtendedXMPHeader)
+		next += copy(buf[next:], guid)
+		binary.BigEndian.PutUint32(buf[next:], uint32(len(data)))
+		binary.BigEndian.PutUint32(buf[next+4:], uint32(offset))
+		copy(buf[ExtendedXMPHeaderSize:], chunk)
+		segments = append(segments, Segment{APP1, buf})
+	}
+	return segments, nil
+}
+
+// extendedXMPChunk is a chunk of Extended XMP data.
+type extendedXMPChunk struct {
+	offset uint32
+	data   []byte
+}
+
+// GetExtendedXMP reassembles the Extended XMP data labelled with
+// 'guid' from the APP1 segments in a list of segments, ordering the
+// chunks by their offsets. GUIDs are compared ignoring case. Returns
+// nil if there's no such data.
+func GetExtendedXMP(segments []Segment, guid string) ([]byte, error) {
+	var chunks []extendedXMPChunk
+	var length uint32
+	var present uint64 // Total size of the chunks found.
+	headerLen := len(ExtendedXMPHeader)
+	for _, segment := range segments {
+		data := segment.Data
+		if segment.Marker != APP1 || len(data) < ExtendedXMPHeaderSize || !bytes.Equal(data[:headerLen], ExtendedXMPHeader) {
+			continue
+		}
+		if !strings.EqualFold(string(data[headerLen:headerLen+32]), guid) {
+			continue
+		}
+		fullLength := binary.BigEndian.Uint32(data[headerLen+32:])
+		if chunks == nil {
+			length = fullLength
+		} else if fullLength != length {
+			return nil, &SyntaxError{-1, APP1, ErrXMPChunks}
+		}
+		chunks = append(chunks, extendedXMPChunk{binary.BigEndian.Uint32(data[headerLen+36:]), data[ExtendedXMPHeaderSize:]})
+		present += uint64(len(data) - ExtendedXMPHeaderSize)
+	}
+	if chunks == nil {
+		return nil, nil
+	}
+	// Check the length from the chunk headers against the data
+	// actually present before allocating for it.
+	if present != uint64(length) {
+		return nil, &SyntaxError{-1, APP1, ErrXMPChunks}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].offset < chunks[j].offset })
+	xmp := make([]byte, 0, length)
+	for _, chunk := range chunks {
+		if chunk.offset != uint32(len(xmp)) || uint64(len(xmp))+uint64(len(chunk.data)) > uint64(length) {
+			return nil, &SyntaxError{-1, APP1, ErrXMPChunks}
+		}
+		xmp = append(xmp, chunk.data...)
+	}
+	if uint32(len(xmp)) != length {
+		return nil, &SyntaxError{-1, APP1, ErrXMPChunks}
+	}
+	return xmp, nil
+}
+
+// MakePhotoshopSegments splits Photoshop image resource data into a
+// sequence of APP13 segments, each with a Photoshop header.
+func MakePhotoshopSegments(data []byte) []Segment {
+	chunkSize := MaxDataSize - PhotoshopHeaderSize
+	var segments []Segment
+	for offset := 0; offset == 0 || offset < len(data); offset += chunkSize {
+		chunk := data[offset:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		buf := make([]byte, PhotoshopHeaderSize+len(chunk))
+		copy(buf, PhotoshopHeader)
+		copy(buf[PhotoshopHeaderSize:], chunk)
+		segments = append(segments, Segment{APP13, buf})
+	}
+	return segments
+}
+
+// GetPhotoshop reassembles Photoshop image resource data from the
+// APP13 segments in a list of segments. Returns nil if there's no
+// such data.
+func GetPhotoshop(segments []Segment) []byte {
+	var data []byte
+	for _, segment := range segments {
+		if segment.Marker == APP13 && len(segment.Data) >= PhotoshopHeaderSize && bytes.Equal(segment.Data[:PhotoshopHeaderSize], PhotoshopHeader) {
+			data = append(data, segment.Data[PhotoshopHeaderSize:]...)
+		}
+	}
+	return data
+}
+
+// DumpICCProfile writes an ICC profile of any length as a sequence of
+// APP2 segments.
+func (dumper *Dumper) DumpICCProfile(profile []byte) error {
+	segments, err := MakeICCSegments(profile)
+	if err != nil {
+		return err
+	}
+	return WriteSegments(dumper, segments)
+}
+
+// DumpExtendedXMP writes Extended XMP data of any length as a
+// sequence of APP1 segments labelled with 'guid'.
+func (dumper *Dumper) DumpExtendedXMP(guid string, data []byte) error {
+	segments, err := MakeExtendedXMPSegments(guid, data)
+	if err != nil {
+		return err
+	}
+	return WriteSegments(dumper, segments)
+}
+
+// DumpPhotoshop writes Photoshop image resource data of any length as
+// a sequence of APP13 segments.
+func (dumper *Dumper) DumpPhotoshop(data []byte) error {
+	return WriteSegments(dumper, MakePhotoshopSegments(data))
+}
+
+// replaceSegments returns a copy of a list of segments with the
+// segments matched by 'match' replaced by 'replacement'. The
+// replacement is inserted in place of the first matching segment, or
+// if there's none, after the APPn segments at the start of the list
+// with markers no higher than the replacement's.
+func replaceSegments(segments []Segment, match func(Segment) bool, replacement []Segment) []Segment {
+	pos := -1
+	var kept []Segment
+	for _, segment := range segments {
+		if match(segment) {
+			if pos < 0 {
+				pos = len(kept)
+			}
+			continue
+		}
+		kept = append(kept, segment)
+	}
+	if pos < 0 {
+		pos = 0
+		for pos < len(kept) && len(replacement) > 0 && kept[pos].Marker >= APP0 && kept[pos].Marker <= replacement[0].Marker {
+			pos++
+		}
+	}
+	result := make([]Segment, 0, len(kept)+len(replacement))
+	result = append(result, kept[:pos]...)
+	result = append(result, replacement...)
+	return append(result, kept[pos:]...)
+}
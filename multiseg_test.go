@@ -0,0 +1,215 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// makeICCProfile returns a profile of 'size' bytes with a valid header.
+func makeICCProfile(size int) []byte {
+	profile := make([]byte, size)
+	binary.BigEndian.PutUint32(profile, uint32(size))
+	copy(profile[36:], "acsp")
+	for i := 128; i < size; i++ {
+		profile[i] = byte(i * 7)
+	}
+	return profile
+}
+
+// payload returns 'size' bytes of test data.
+func payload(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*13 + i>>8)
+	}
+	return data
+}
+
+func TestMultiSegmentRoundTrip(t *testing.T) {
+	guid := ExtendedXMPGUID(nil)
+	sizes := []int{0, 1, MaxDataSize - ICCHeaderSize, MaxDataSize - ExtendedXMPHeaderSize + 1, 3*MaxDataSize + 100}
+	for _, size := range sizes {
+		profile := makeICCProfile(size + 128)
+		data := payload(size)
+		var buf bytes.Buffer
+		dumper, err := NewDumper(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.DumpICCProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.DumpExtendedXMP(guid, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.DumpPhotoshop(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := dumper.Dump(EOI, nil); err != nil {
+			t.Fatal(err)
+		}
+		segments, err := scanSegments(newTestScanner(t, buf.Bytes(), false))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if got, err := GetICCProfile(segments); err != nil || !bytes.Equal(got, profile) {
+			t.Errorf("size %d: ICC profile differs: %v", size, err)
+		}
+		got, err := GetExtendedXMP(segments, guid)
+		if err != nil || !bytes.Equal(got, data) || size > 0 && got == nil {
+			t.Errorf("size %d: Extended XMP differs: %v", size, err)
+		}
+		if got := GetPhotoshop(segments); !bytes.Equal(got, data) {
+			t.Errorf("size %d: Photoshop data differs", size)
+		}
+	}
+}
+
+func TestICCSequence(t *testing.T) {
+	segments, err := MakeICCSegments(makeICCProfile(2*(MaxDataSize-ICCHeaderSize) + 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("%d chunks, expected 3", len(segments))
+	}
+	chunk := func(seq, count byte) Segment {
+		data := append([]byte(nil), segments[0].Data...)
+		data[ICCHeaderSize-2] = seq
+		data[ICCHeaderSize-1] = count
+		return Segment{APP2, data}
+	}
+	tests := []struct {
+		name     string
+		segments []Segment
+		err      error
+	}{
+		{"in order", segments, nil},
+		{"reversed", []Segment{segments[2], segments[1], segments[0]}, nil},
+		{"missing chunk", segments[:2], ErrICCSequence},
+		{"duplicate chunk", []Segment{segments[0], segments[0], segments[2]}, ErrICCSequence},
+		{"sequence zero", []Segment{chunk(0, 3), segments[1], segments[2]}, ErrICCSequence},
+		{"sequence past count", append(segments[:3:3], chunk(4, 3)), ErrICCSequence},
+		{"inconsistent count", []Segment{chunk(1, 4), segments[1], segments[2]}, ErrICCSequence},
+	}
+	for _, test := range tests {
+		if _, err := GetICCProfile(test.segments); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+	}
+	if _, err := MakeICCSegments(make([]byte, 255*(MaxDataSize-ICCHeaderSize)+1)); err != ErrICCTooLong {
+		t.Errorf("got %v for an oversized profile, expected %v", err, ErrICCTooLong)
+	}
+	bad := makeICCProfile(200)
+	bad[36] = 'x'
+	for _, profile := range [][]byte{bad, makeICCProfile(200)[:199], make([]byte, 100)} {
+		if err := CheckICCProfile(profile); !errors.Is(err, ErrICCProfile) {
+			t.Errorf("profile of %d bytes: got %v, expected %v", len(profile), err, ErrICCProfile)
+		}
+	}
+	if err := CheckICCProfile(makeICCProfile(200)); err != nil {
+		t.Errorf("valid profile: %v", err)
+	}
+}
+
+func TestExtendedXMPChunks(t *testing.T) {
+	data := payload(2*(MaxDataSize-ExtendedXMPHeaderSize) + 10)
+	guid := ExtendedXMPGUID(data)
+	segments, err := MakeExtendedXMPSegments(guid, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// setLength returns a copy of a chunk with a different full
+	// length in its header.
+	setLength := func(segment Segment, length uint32) Segment {
+		buf := append([]byte(nil), segment.Data...)
+		binary.BigEndian.PutUint32(buf[len(ExtendedXMPHeader)+32:], length)
+		return Segment{APP1, buf}
+	}
+	otherGUID := ExtendedXMPGUID(data[:10])
+	other, err := MakeExtendedXMPSegments(otherGUID, data[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		segments []Segment
+		guid     string
+		err      error
+	}{
+		{"reversed", []Segment{segments[2], segments[1], segments[0]}, guid, nil},
+		{"other GUID mixed in", append(other, segments...), guid, nil},
+		{"missing chunk", []Segment{segments[0], segments[2]}, guid, ErrXMPChunks},
+		{"duplicate chunk", append(segments[:3:3], segments[1]), guid, ErrXMPChunks},
+		{"inconsistent length", []Segment{segments[0], segments[1], setLength(segments[2], 1)}, guid, ErrXMPChunks},
+		// A header mustn't cause a huge allocation.
+		{"huge length", []Segment{setLength(other[0], 0xFFFFFFFF)}, otherGUID, ErrXMPChunks},
+	}
+	for _, test := range tests {
+		got, err := GetExtendedXMP(test.segments, test.guid)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+		if err == nil && !bytes.Equal(got, data) {
+			t.Errorf("%s: reassembled data differs", test.name)
+		}
+	}
+	if _, err := MakeExtendedXMPSegments("short", data); err != ErrXMPGUID {
+		t.Errorf("got %v for a short GUID, expected %v", err, ErrXMPGUID)
+	}
+}
+
+func TestSetICCProfile(t *testing.T) {
+	profile := makeICCProfile(300)
+	old, err := MakeICCSegments(makeICCProfile(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jfif := Segment{APP0, []byte("JFIF\000")}
+	exif := Segment{APP1, []byte("Exif\000\000")}
+	mpf := Segment{APP2, []byte("MPF\000")}
+	dqt := Segment{DQT, nil}
+	// Expected lists have an empty segment in place of the new profile.
+	tests := []struct {
+		name     string
+		segments []Segment
+		expected []Segment
+	}{
+		{"no APPn", []Segment{dqt}, []Segment{{}, dqt}},
+		{"after APP0 and APP1", []Segment{jfif, exif, dqt}, []Segment{jfif, exif, {}, dqt}},
+		{"before MPF", []Segment{jfif, exif, mpf, dqt}, []Segment{jfif, exif, {}, mpf, dqt}},
+		{"replacing old chunk", []Segment{jfif, old[0], exif, dqt}, []Segment{jfif, {}, exif, dqt}},
+		{"moved before MPF", []Segment{jfif, mpf, old[0], dqt}, []Segment{jfif, {}, mpf, dqt}},
+	}
+	for _, test := range tests {
+		result, err := SetICCProfile(test.segments, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != len(test.expected) {
+			t.Fatalf("%s: %d segments, expected %d", test.name, len(result), len(test.expected))
+		}
+		for i, segment := range test.expected {
+			if segment.Marker == 0 {
+				if !isICCSegment(result[i]) {
+					t.Errorf("%s: segment %d isn't an ICC chunk", test.name, i)
+				}
+			} else if result[i].Marker != segment.Marker || !bytes.Equal(result[i].Data, segment.Data) {
+				t.Errorf("%s: segment %d is %s, expected %s", test.name, i, result[i].Marker.Name(), segment.Marker.Name())
+			}
+		}
+		got, err := GetICCProfile(result)
+		if err != nil || !bytes.Equal(got, profile) {
+			t.Errorf("%s: profile not replaced: %v", test.name, err)
+		}
+		removed, err := SetICCProfile(result, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := GetICCProfile(removed); got != nil || len(removed) != len(result)-1 {
+			t.Errorf("%s: profile not removed", test.name)
+		}
+	}
+}
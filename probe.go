@@ -0,0 +1,134 @@
+package jpegsegs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImageInfo summarizes the format of a JPEG image, as returned by
+// Probe.
+type ImageInfo struct {
+	Width        int
+	Height       int
+	Components   int           // Number of image components.
+	Precision    int           // Sample precision in bits.
+	Progressive  bool          // True for progressive, false for sequential coding.
+	Hierarchical bool          // True if the image has a DHP segment and a frame for each resolution.
+	Process      CodingProcess // Coding process, from the SOFn marker.
+	Subsampling  string        // Chroma subsampling, see FrameHeader.Subsampling.
+	ScanOffset   int64         // Position of the first SOS marker.
+}
+
+// Probe reads the start of a JPEG image to determine its dimensions
+// and format, stopping after the first SOS segment. If the frame
+// header doesn't give the height, reading continues to the DNL
+// segment following the first scan. The dimensions of a hierarchical
+// image are taken from its DHP segment, and the rest of the format
+// from its first frame.
+func Probe(reader io.ReadSeeker) (*ImageInfo, error) {
+	scanner, err := NewScanner(reader)
+	if err != nil {
+		return nil, err
+	}
+	var info *ImageInfo
+	var hierarchy *FrameHeader
+	for {
+		marker, buf, err := scanner.Scan()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker.IsSOF() && info == nil:
+			frame, err := GetFrameHeader(buf)
+			if err != nil {
+				return nil, locate(err, scanner.Offsets().Marker, marker)
+			}
+			process := marker.Process()
+			info = &ImageInfo{
+				Width:       int(frame.Width),
+				Height:      int(frame.Height),
+				Components:  len(frame.Components),
+				Precision:   int(frame.Precision),
+				Progressive: process.Progressive,
+				Process:     process,
+				Subsampling: frame.Subsampling(),
+				ScanOffset:  -1}
+			if hierarchy != nil {
+				info.Width = int(hierarchy.Width)
+				info.Height = int(hierarchy.Height)
+				info.Hierarchical = true
+			}
+		case marker == DHP && info == nil:
+			if hierarchy, err = GetHierarchyHeader(buf); err != nil {
+				return nil, locate(err, scanner.Offsets().Marker, DHP)
+			}
+		case marker == SOS:
+			if info == nil {
+				return nil, &SyntaxError{scanner.Offsets().Marker, SOS, ErrNoFrame}
+			}
+			if info.ScanOffset < 0 {
+				info.ScanOffset = scanner.Offsets().Marker
+			}
+			if info.Height != 0 {
+				return info, nil
+			}
+		case marker == DNL:
+			if info == nil || len(buf) != 2 {
+				return nil, &SyntaxError{scanner.Offsets().Marker, DNL, ErrDNL}
+			}
+			info.Height = int(buf[0])<<8 | int(buf[1])
+			if info.ScanOffset >= 0 {
+				return info, nil
+			}
+		case marker == EOI:
+			if info == nil {
+				return nil, &SyntaxError{scanner.Offsets().Marker, EOI, ErrNoFrame}
+			}
+			return nil, &SyntaxError{scanner.Offsets().Marker, EOI, ErrDNL}
+		}
+	}
+}
+
+// Subsampling describes the chroma subsampling of a frame using the
+// usual J:a:b notation, e.g., "4:2:0" if the first component has
+// twice the horizontal and vertical resolution of the others.
+// Grayscale images are "4:0:0". Arrangements with no such description
+// are given as a list of the components' sampling factors, e.g.,
+// "2x2,1x2,1x1".
+func (frame *FrameHeader) Subsampling() string {
+	comps := frame.Components
+	if len(comps) == 1 {
+		return "4:0:0"
+	}
+	uniform := true
+	for _, comp := range comps[2:] {
+		if comp.H != comps[1].H || comp.V != comps[1].V {
+			uniform = false
+		}
+	}
+	if uniform && comps[0].H%comps[1].H == 0 && comps[0].V%comps[1].V == 0 {
+		h := comps[0].H / comps[1].H
+		v := comps[0].V / comps[1].V
+		switch {
+		case h == 1 && v == 1 && len(comps) >= 3:
+			return "4:4:4" + strings.Repeat(":4", len(comps)-3)
+		case len(comps) != 3:
+		case h == 2 && v == 1:
+			return "4:2:2"
+		case h == 2 && v == 2:
+			return "4:2:0"
+		case h == 1 && v == 2:
+			return "4:4:0"
+		case h == 4 && v == 1:
+			return "4:1:1"
+		case h == 4 && v == 2:
+			return "4:1:0"
+		}
+	}
+	factors := make([]string, len(comps))
+	for i, comp := range comps {
+		factors[i] = fmt.Sprintf("%dx%d", comp.H, comp.V)
+	}
+	return strings.Join(factors, ",")
+}
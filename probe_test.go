@@ -0,0 +1,109 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// removeSegment returns a copy of 'data' without the first segment
+// with the given marker.
+func removeSegment(t testing.TB, data []byte, marker Marker) []byte {
+	t.Helper()
+	pos := bytes.Index(data, []byte{0xFF, byte(marker)})
+	if pos < 0 {
+		t.Fatalf("%s marker not found", marker.Name())
+	}
+	end := pos + 2 + (int(data[pos+2])<<8 | int(data[pos+3]))
+	return append(data[:pos:pos], data[end:]...)
+}
+
+// setHeight returns a copy of 'data' with the height in the SOF0
+// segment replaced by zero, and a DNL segment with the real height
+// before EOI if 'dnl' is true.
+func setHeight(t testing.TB, data []byte, dnl bool) []byte {
+	t.Helper()
+	pos := bytes.Index(data, []byte{0xFF, byte(SOF0)})
+	if pos < 0 {
+		t.Fatal("SOF0 marker not found")
+	}
+	result := append([]byte(nil), data[:len(data)-2]...)
+	height := result[pos+5 : pos+7]
+	if dnl {
+		result = append(result, 0xFF, byte(DNL), 0, 4, height[0], height[1])
+	}
+	height[0], height[1] = 0, 0
+	return append(result, 0xFF, byte(EOI))
+}
+
+func TestProbe(t *testing.T) {
+	color := makeJPEG(t, 40, 24, false)
+	sosOffset := int64(bytes.Index(color, []byte{0xFF, byte(SOS)}))
+	noFrame := removeSegment(t, color, SOF0)
+	noFrameSOS := int64(bytes.Index(noFrame, []byte{0xFF, byte(SOS)}))
+	tests := []struct {
+		name   string
+		data   []byte
+		info   ImageInfo
+		err    error
+		offset int64 // Offset of the SyntaxError.
+	}{
+		{"color", color, ImageInfo{40, 24, 3, 8, false, false, Marker(SOF0).Process(), "4:2:0", sosOffset}, nil, 0},
+		{"gray", makeJPEG(t, 17, 9, true), ImageInfo{17, 9, 1, 8, false, false, Marker(SOF0).Process(), "4:0:0", -1}, nil, 0},
+		{"height from DNL", setHeight(t, color, true), ImageInfo{40, 24, 3, 8, false, false, Marker(SOF0).Process(), "4:2:0", sosOffset}, nil, 0},
+		{"no DNL", setHeight(t, color, false), ImageInfo{}, ErrDNL, int64(len(color) - 2)},
+		{"scan before frame", noFrame, ImageInfo{}, ErrNoFrame, noFrameSOS},
+		{"no frame", []byte{0xFF, byte(SOI), 0xFF, byte(EOI)}, ImageInfo{}, ErrNoFrame, 2},
+	}
+	for _, test := range tests {
+		info, err := Probe(bytes.NewReader(test.data))
+		if test.err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.Is(err, test.err) || !errors.As(err, &syntaxErr) || syntaxErr.Offset != test.offset {
+				t.Errorf("%s: got %v, expected %v at %d", test.name, err, test.err, test.offset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if test.info.ScanOffset < 0 {
+			// Not checked.
+			test.info.ScanOffset = info.ScanOffset
+		}
+		if *info != test.info {
+			t.Errorf("%s: got %+v, expected %+v", test.name, *info, test.info)
+		}
+	}
+}
+
+func TestSubsampling(t *testing.T) {
+	tests := []struct {
+		factors     []uint8 // H and V for each component.
+		subsampling string
+	}{
+		{[]uint8{1, 1}, "4:0:0"},
+		{[]uint8{2, 2}, "4:0:0"},
+		{[]uint8{1, 1, 1, 1, 1, 1}, "4:4:4"},
+		{[]uint8{2, 2, 2, 2, 2, 2}, "4:4:4"},
+		{[]uint8{1, 1, 1, 1, 1, 1, 1, 1}, "4:4:4:4"},
+		{[]uint8{2, 1, 1, 1, 1, 1}, "4:2:2"},
+		{[]uint8{2, 2, 1, 1, 1, 1}, "4:2:0"},
+		{[]uint8{1, 2, 1, 1, 1, 1}, "4:4:0"},
+		{[]uint8{4, 1, 1, 1, 1, 1}, "4:1:1"},
+		{[]uint8{4, 2, 1, 1, 1, 1}, "4:1:0"},
+		{[]uint8{2, 2, 1, 2, 1, 1}, "2x2,1x2,1x1"},
+		{[]uint8{3, 1, 2, 1, 2, 1}, "3x1,2x1,2x1"},
+		{[]uint8{2, 2, 1, 1, 1, 1, 1, 1}, "2x2,1x1,1x1,1x1"},
+	}
+	for _, test := range tests {
+		var frame FrameHeader
+		for i := 0; i < len(test.factors); i += 2 {
+			frame.Components = append(frame.Components, FrameComponent{uint8(i/2 + 1), test.factors[i], test.factors[i+1], 0})
+		}
+		if got := frame.Subsampling(); got != test.subsampling {
+			t.Errorf("%v: got %q, expected %q", test.factors, got, test.subsampling)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package jpegsegs
+
+// ZigZag maps the position of a coefficient in zig-zag order, as used
+// in DQT segments and entropy-coded data, to its position in natural
+// (row-major) order in an 8x8 block.
+var ZigZag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// ZigZagToNatural reorders 64 values from zig-zag to natural order.
+func ZigZagToNatural(values [64]uint16) [64]uint16 {
+	var natural [64]uint16
+	for i, v := range values {
+		natural[ZigZag[i]] = v
+	}
+	return natural
+}
+
+// NaturalToZigZag reorders 64 values from natural to zig-zag order.
+func NaturalToZigZag(values [64]uint16) [64]uint16 {
+	var zigzag [64]uint16
+	for i := range zigzag {
+		zigzag[i] = values[ZigZag[i]]
+	}
+	return zigzag
+}
+
+// QuantTable is a quantization table from a DQT segment.
+type QuantTable struct {
+	Precision uint8      // 0 for 8 bit values, 1 for 16 bit values.
+	Dest      uint8      // Destination identifier, 0-3.
+	Values    [64]uint16 // Quantization values in zig-zag order.
+}
+
+// GetQuantTables decodes the quantization tables in a DQT segment.
+func GetQuantTables(buf []byte) ([]QuantTable, error) {
+	var tables []QuantTable
+	for pos := 0; pos < len(buf); {
+		var table QuantTable
+		table.Precision = buf[pos] >> 4
+		table.Dest = buf[pos] & 0xF
+		pos++
+		if table.Precision > 1 || table.Dest > 3 || len(buf)-pos < 64*(1+int(table.Precision)) {
+			return nil, &SyntaxError{-1, DQT, ErrQuantTable}
+		}
+		for i := range table.Values {
+			if table.Precision == 0 {
+				table.Values[i] = uint16(buf[pos])
+				pos++
+			} else {
+				table.Values[i] = uint16(buf[pos])<<8 | uint16(buf[pos+1])
+				pos += 2
+			}
+		}
+		tables = append(tables, table)
+	}
+	if len(tables) == 0 {
+		return nil, &SyntaxError{-1, DQT, ErrQuantTable}
+	}
+	return tables, nil
+}
+
+// MakeQuantSegment encodes quantization tables into a newly allocated
+// slice, which can be used as a DQT segment.
+func MakeQuantSegment(tables []QuantTable) []byte {
+	var buf []byte
+	for _, table := range tables {
+		buf = append(buf, table.Precision<<4|table.Dest&0xF)
+		for _, v := range table.Values {
+			if table.Precision == 0 {
+				buf = append(buf, byte(v))
+			} else {
+				buf = append(buf, byte(v>>8), byte(v))
+			}
+		}
+	}
+	return buf
+}
+
+// StandardLuminanceQuant is the example luminance quantization table
+// from Annex K of the JPEG standard, in natural order. It's used by
+// the IJG libjpeg library, scaled according to the quality setting.
+var StandardLuminanceQuant = [64]uint16{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// StandardChrominanceQuant is the example chrominance quantization
+// table from Annex K of the JPEG standard, in natural order.
+var StandardChrominanceQuant = [64]uint16{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// ScaleQuantTable scales a quantization table for a quality factor
+// from 1 to 100, as done by libjpeg. If 'baseline' is true, values
+// are limited to 255 so that the table can be stored with 8 bit
+// precision.
+func ScaleQuantTable(table [64]uint16, quality int, baseline bool) [64]uint16 {
+	if quality < 1 {
+		quality = 1
+	}
+	if quality > 100 {
+		quality = 100
+	}
+	var scale int
+	if quality < 50 {
+		scale = 5000 / quality
+	} else {
+		scale = 200 - quality*2
+	}
+	var scaled [64]uint16
+	for i, v := range table {
+		temp := (int(v)*scale + 50) / 100
+		if temp < 1 {
+			temp = 1
+		}
+		if temp > 32767 {
+			temp = 32767
+		}
+		if baseline && temp > 255 {
+			temp = 255
+		}
+		scaled[i] = uint16(temp)
+	}
+	return scaled
+}
+
+// QualityEstimate is the result of EstimateQuality.
+type QualityEstimate struct {
+	// Quality is the libjpeg quality factor, 1-100, whose tables
+	// are closest to the image's tables.
+	Quality int
+	// Standard is true if the image's tables are exactly the
+	// standard tables scaled for Quality.
+	Standard bool
+	// Difference is the mean absolute difference between the
+	// image's table values and those of the standard tables scaled
+	// for Quality.
+	Difference float64
+}
+
+// EstimateQuality compares the luminance and chrominance quantization
+// tables of an image, which are assumed to be tables 0 and 1, against
+// the standard tables scaled for each quality factor. If there's more
+// than one table with the same destination, the last is used.
+// Returns false if there's no luminance table.
+func EstimateQuality(tables []QuantTable) (QualityEstimate, bool) {
+	var luma, chroma *QuantTable
+	for i := range tables {
+		switch tables[i].Dest {
+		case 0:
+			luma = &tables[i]
+		case 1:
+			chroma = &tables[i]
+		}
+	}
+	if luma == nil {
+		return QualityEstimate{}, false
+	}
+	compare := []*QuantTable{luma}
+	standard := [][64]uint16{StandardLuminanceQuant}
+	if chroma != nil {
+		compare = append(compare, chroma)
+		standard = append(standard, StandardChrominanceQuant)
+	}
+	best := QualityEstimate{}
+	bestDiff := -1
+	for quality := 1; quality <= 100; quality++ {
+		diff := 0
+		for i, table := range compare {
+			scaled := ScaleQuantTable(standard[i], quality, table.Precision == 0)
+			natural := ZigZagToNatural(table.Values)
+			for j := range natural {
+				if natural[j] > scaled[j] {
+					diff += int(natural[j] - scaled[j])
+				} else {
+					diff += int(scaled[j] - natural[j])
+				}
+			}
+		}
+		if bestDiff < 0 || diff < bestDiff {
+			bestDiff = diff
+			best.Quality = quality
+		}
+	}
+	best.Standard = bestDiff == 0
+	best.Difference = float64(bestDiff) / float64(64*len(compare))
+	return best, true
+}
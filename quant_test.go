@@ -0,0 +1,114 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+func TestZigZag(t *testing.T) {
+	var natural [64]uint16
+	for i := range natural {
+		natural[i] = uint16(i)
+	}
+	zigzag := NaturalToZigZag(natural)
+	// The third value in zig-zag order is the first of the second row.
+	if zigzag[0] != 0 || zigzag[1] != 1 || zigzag[2] != 8 || zigzag[63] != 63 {
+		t.Errorf("unexpected zig-zag order %v", zigzag)
+	}
+	if got := ZigZagToNatural(zigzag); got != natural {
+		t.Errorf("round trip returned %v", got)
+	}
+}
+
+func TestGetQuantTables(t *testing.T) {
+	var values [64]uint16
+	for i := range values {
+		values[i] = uint16(i + 1)
+	}
+	var wide [64]uint16
+	for i := range wide {
+		wide[i] = uint16(i * 1000)
+	}
+	table8 := QuantTable{0, 0, values}
+	table16 := QuantTable{1, 3, wide}
+	valid := MakeQuantSegment([]QuantTable{table8, table16})
+	if len(valid) != 1+64+1+128 {
+		t.Fatalf("segment of %d bytes", len(valid))
+	}
+	tests := []struct {
+		name   string
+		buf    []byte
+		tables []QuantTable
+	}{
+		{"8 bit", valid[:65], []QuantTable{table8}},
+		{"16 bit", valid[65:], []QuantTable{table16}},
+		{"two tables", valid, []QuantTable{table8, table16}},
+		{"empty", nil, nil},
+		{"short", valid[:64], nil},
+		{"short second table", valid[:len(valid)-1], nil},
+		{"precision 2", append([]byte{0x20}, valid[1:65]...), nil},
+		{"destination 4", append([]byte{0x04}, valid[1:65]...), nil},
+	}
+	for _, test := range tests {
+		tables, err := GetQuantTables(test.buf)
+		if test.tables == nil {
+			if !errors.Is(err, ErrQuantTable) {
+				t.Errorf("%s: got %v, expected %v", test.name, err, ErrQuantTable)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tables, test.tables) {
+			t.Errorf("%s: got %v", test.name, tables)
+		}
+	}
+}
+
+func TestEstimateQuality(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	// Qualities below 10 can't always be distinguished, since
+	// image/jpeg limits the table values to 255.
+	for _, quality := range []int{10, 25, 50, 75, 90, 95, 100} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		var tables []QuantTable
+		segments, err := scanSegments(newTestScanner(t, buf.Bytes(), false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, segment := range segments {
+			if segment.Marker == DQT {
+				got, err := GetQuantTables(segment.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tables = append(tables, got...)
+			}
+		}
+		estimate, ok := EstimateQuality(tables)
+		if !ok || estimate != (QualityEstimate{quality, true, 0}) {
+			t.Errorf("quality %d: got %+v, %v", quality, estimate, ok)
+		}
+	}
+	// A table that's not a scaled standard table.
+	var flat [64]uint16
+	for i := range flat {
+		flat[i] = 10
+	}
+	estimate, ok := EstimateQuality([]QuantTable{{0, 0, flat}})
+	if !ok || estimate.Standard || estimate.Difference == 0 {
+		t.Errorf("flat table: got %+v, %v", estimate, ok)
+	}
+	if _, ok := EstimateQuality([]QuantTable{{0, 1, flat}}); ok {
+		t.Error("estimate without a luminance table")
+	}
+}
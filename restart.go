@@ -0,0 +1,35 @@
+package jpegsegs
+
+// ValidateRestarts reads an image from a scanner, up to and including
+// the EOI marker, and decodes its image data to check that the RST
+// markers in each scan are numbered in sequence and occur after every
+// restart interval given by the DRI segment. A problem with the
+// markers is reported as a SyntaxError with reason ErrRestart.
+func ValidateRestarts(scanner *Scanner) error {
+	dec := NewDecoder()
+	dec.CheckRestarts = true
+	return dec.Decode(scanner)
+}
+
+// SetRestartInterval reads an image from a scanner and writes it to a
+// dumper with a new restart interval, in MCUs, or without RST markers
+// or a DRI segment if 'interval' is 0. Progressive images are
+// reencoded with their original scan script, and sequential images
+// with optimized Huffman tables. Arithmetic-coded images remain
+// arithmetic-coded.
+func SetRestartInterval(scanner *Scanner, dumper *Dumper, interval int) error {
+	if interval < 0 || interval > 0xFFFF {
+		return ErrRestartInterval
+	}
+	coeffs, segments, err := ReadCoefficients(scanner)
+	if err != nil {
+		return err
+	}
+	coeffs.RestartInterval = interval
+	process := coeffs.Marker.Process()
+	options := &EncodeOptions{OptimizeHuffman: true, Arithmetic: process.Arithmetic}
+	if process.Progressive {
+		options = &EncodeOptions{Progressive: true, Scans: coeffs.Scans, Arithmetic: process.Arithmetic}
+	}
+	return WriteCoefficients(dumper, coeffs, segments, options)
+}
@@ -0,0 +1,90 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// setRestartInterval rewrites an image with a new restart interval.
+func setRestartInterval(t *testing.T, data []byte, interval int) []byte {
+	t.Helper()
+	scanner, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	dumper, _ := NewDumper(&buf)
+	if err := SetRestartInterval(scanner, dumper, interval); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// validateRestarts calls ValidateRestarts on an image.
+func validateRestarts(t *testing.T, data []byte) error {
+	t.Helper()
+	scanner, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ValidateRestarts(scanner)
+}
+
+func TestSetRestartInterval(t *testing.T) {
+	data := makeJPEG(t, 203, 117, false)
+	coeffs, segments := readCoefficients(t, data)
+	for _, progressive := range []bool{false, true} {
+		in := writeCoefficients(t, coeffs, segments, &EncodeOptions{Progressive: progressive})
+		out := setRestartInterval(t, in, 4)
+		if err := validateRestarts(t, out); err != nil {
+			t.Fatalf("progressive %v: %v", progressive, err)
+		}
+		decoded, _ := readCoefficients(t, out)
+		if decoded.RestartInterval != 4 || decoded.Marker.Process().Progressive != progressive {
+			t.Errorf("progressive %v: %s frame with restart interval %d", progressive, decoded.Marker.Name(), decoded.RestartInterval)
+		}
+		sameCoefficients(t, coeffs, decoded)
+		removed := setRestartInterval(t, out, 0)
+		if bytes.Contains(removed, []byte{0xFF, byte(DRI)}) || bytes.Contains(removed, []byte{0xFF, byte(RST0)}) {
+			t.Errorf("progressive %v: restarts weren't removed", progressive)
+		}
+		if err := validateRestarts(t, removed); err != nil {
+			t.Errorf("progressive %v: %v", progressive, err)
+		}
+	}
+}
+
+func TestValidateRestarts(t *testing.T) {
+	data := makeJPEG(t, 203, 117, false)
+	if err := validateRestarts(t, data); err != nil {
+		t.Fatal(err)
+	}
+	data = setRestartInterval(t, data, 4)
+	rst1 := bytes.Index(data, []byte{0xFF, byte(RST0 + 1)})
+	tests := []struct {
+		name   string
+		edit   func(data []byte) []byte
+		offset int64 // Expected error offset, or -1 to not check.
+	}{
+		{"misnumbered", func(data []byte) []byte {
+			data[rst1+1] = byte(RST0 + 3)
+			return data
+		}, int64(rst1)},
+		{"missing", func(data []byte) []byte {
+			return append(data[:rst1], data[rst1+2:]...)
+		}, -1},
+	}
+	for _, test := range tests {
+		bad := test.edit(append([]byte(nil), data...))
+		err := validateRestarts(t, bad)
+		var syntaxErr *SyntaxError
+		if !errors.Is(err, ErrRestart) || !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: got %v, expected ErrRestart", test.name, err)
+			continue
+		}
+		if test.offset >= 0 && syntaxErr.Offset != test.offset {
+			t.Errorf("%s: error at offset %d, expected %d", test.name, syntaxErr.Offset, test.offset)
+		}
+	}
+}
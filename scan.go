@@ -0,0 +1,106 @@
+package jpegsegs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScanComponent describes an image component in a scan header.
+type ScanComponent struct {
+	ID uint8 // Component selector, matching a FrameComponent ID.
+	Td uint8 // DC entropy coding table selector, 0-3.
+	Ta uint8 // AC entropy coding table selector, 0-3.
+}
+
+// ScanHeader holds the data from a SOS segment.
+type ScanHeader struct {
+	Components []ScanComponent
+	Ss         uint8 // Start of spectral selection, or predictor for lossless scans.
+	Se         uint8 // End of spectral selection.
+	Ah         uint8 // Successive approximation bit position high.
+	Al         uint8 // Successive approximation bit position low, or point transform.
+}
+
+// GetScanHeader decodes the data from a SOS segment.
+func GetScanHeader(buf []byte) (*ScanHeader, error) {
+	if len(buf) < 1 {
+		return nil, &SyntaxError{-1, SOS, ErrScanHeader}
+	}
+	count := int(buf[0])
+	if count < 1 || count > 4 || len(buf) != 4+2*count {
+		return nil, &SyntaxError{-1, SOS, ErrScanHeader}
+	}
+	var scan ScanHeader
+	scan.Components = make([]ScanComponent, count)
+	for i := range scan.Components {
+		spec := buf[1+2*i:]
+		comp := ScanComponent{spec[0], spec[1] >> 4, spec[1] & 0xF}
+		if comp.Td > 3 || comp.Ta > 3 {
+			return nil, &SyntaxError{-1, SOS, ErrScanHeader}
+		}
+		scan.Components[i] = comp
+	}
+	params := buf[1+2*count:]
+	scan.Ss = params[0]
+	scan.Se = params[1]
+	scan.Ah = params[2] >> 4
+	scan.Al = params[2] & 0xF
+	return &scan, nil
+}
+
+// MakeScanSegment encodes a scan header into a newly allocated slice,
+// which can be used as a SOS segment.
+func MakeScanSegment(scan *ScanHeader) []byte {
+	count := len(scan.Components)
+	buf := make([]byte, 4+2*count)
+	buf[0] = byte(count)
+	for i, comp := range scan.Components {
+		buf[1+2*i] = comp.ID
+		buf[2+2*i] = comp.Td<<4 | comp.Ta&0xF
+	}
+	params := buf[1+2*count:]
+	params[0] = scan.Ss
+	params[1] = scan.Se
+	params[2] = scan.Ah<<4 | scan.Al&0xF
+	return buf
+}
+
+// ComponentName returns a conventional name for a component
+// identifier in a frame: Y, Cb and Cr for identifiers 1 to 3 in
+// frames with one or three components, as used by JFIF, the
+// character for printable identifiers such as 'R', otherwise the
+// number.
+func ComponentName(frame *FrameHeader, id uint8) string {
+	count := len(frame.Components)
+	if (count == 1 || count == 3) && id >= 1 && id <= 3 {
+		return []string{"Y", "Cb", "Cr"}[id-1]
+	}
+	if id >= 'A' && id <= 'Z' || id >= 'a' && id <= 'z' {
+		return string(rune(id))
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+// Describe summarizes a scan in a frame using the given coding
+// process, e.g., "Y, AC 1-5, Al=2" for a progressive scan.
+func (scan *ScanHeader) Describe(frame *FrameHeader, process CodingProcess) string {
+	names := make([]string, len(scan.Components))
+	for i, comp := range scan.Components {
+		names[i] = ComponentName(frame, comp.ID)
+	}
+	desc := strings.Join(names, " ")
+	switch {
+	case process.Lossless:
+		return fmt.Sprintf("%s, predictor %d, Pt=%d", desc, scan.Ss, scan.Al)
+	case !process.Progressive:
+		return fmt.Sprintf("%s, DC and AC 1-%d", desc, scan.Se)
+	case scan.Ss == 0:
+		desc += ", DC"
+	default:
+		desc += fmt.Sprintf(", AC %d-%d", scan.Ss, scan.Se)
+	}
+	if scan.Ah != 0 {
+		desc += fmt.Sprintf(", Ah=%d", scan.Ah)
+	}
+	return desc + fmt.Sprintf(", Al=%d", scan.Al)
+}
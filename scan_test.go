@@ -0,0 +1,83 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGetScanHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		scan *ScanHeader
+	}{
+		{"sequential", []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0},
+			&ScanHeader{[]ScanComponent{{1, 0, 0}, {2, 1, 1}, {3, 1, 1}}, 0, 63, 0, 0}},
+		{"progressive AC", []byte{1, 1, 0x02, 1, 5, 0x21},
+			&ScanHeader{[]ScanComponent{{1, 0, 2}}, 1, 5, 2, 1}},
+		{"lossless", []byte{1, 'R', 0x30, 6, 0, 2},
+			&ScanHeader{[]ScanComponent{{'R', 3, 0}}, 6, 0, 0, 2}},
+		{"four components", []byte{4, 1, 0, 2, 0, 3, 0, 4, 0, 0, 63, 0},
+			&ScanHeader{[]ScanComponent{{1, 0, 0}, {2, 0, 0}, {3, 0, 0}, {4, 0, 0}}, 0, 63, 0, 0}},
+		{"empty", nil, nil},
+		{"no components", []byte{0, 0, 63, 0}, nil},
+		{"five components", []byte{5, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 0, 63, 0}, nil},
+		{"short", []byte{1, 1, 0, 0, 63}, nil},
+		{"extra bytes", []byte{1, 1, 0, 0, 63, 0, 0}, nil},
+		{"DC table 4", []byte{1, 1, 0x40, 0, 63, 0}, nil},
+		{"AC table 4", []byte{1, 1, 0x04, 0, 63, 0}, nil},
+	}
+	for _, test := range tests {
+		scan, err := GetScanHeader(test.buf)
+		if test.scan == nil {
+			if !errors.Is(err, ErrScanHeader) {
+				t.Errorf("%s: got %v, expected %v", test.name, err, ErrScanHeader)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(scan, test.scan) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, scan, test.scan)
+		}
+		if buf := MakeScanSegment(scan); !bytes.Equal(buf, test.buf) {
+			t.Errorf("%s: re-encoded as %v", test.name, buf)
+		}
+	}
+	// The scan header written by image/jpeg.
+	_, buf := findSegment(t, makeJPEG(t, 40, 24, false), func(m Marker) bool { return m == SOS })
+	scan, err := GetScanHeader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Components) != 3 || scan.Ss != 0 || scan.Se != 63 || scan.Ah != 0 || scan.Al != 0 {
+		t.Errorf("image/jpeg scan header %+v", scan)
+	}
+}
+
+func TestDescribeScan(t *testing.T) {
+	color := &FrameHeader{8, 8, 8, []FrameComponent{{1, 2, 2, 0}, {2, 1, 1, 1}, {3, 1, 1, 1}}}
+	rgb := &FrameHeader{8, 8, 8, []FrameComponent{{'R', 1, 1, 0}, {'G', 1, 1, 0}, {'B', 1, 1, 0}}}
+	cmyk := &FrameHeader{8, 8, 8, []FrameComponent{{1, 1, 1, 0}, {2, 1, 1, 0}, {3, 1, 1, 0}, {4, 1, 1, 0}}}
+	tests := []struct {
+		frame    *FrameHeader
+		marker   Marker
+		scan     ScanHeader
+		describe string
+	}{
+		{color, SOF0, ScanHeader{[]ScanComponent{{1, 0, 0}, {2, 1, 1}, {3, 1, 1}}, 0, 63, 0, 0}, "Y Cb Cr, DC and AC 1-63"},
+		{color, SOF2, ScanHeader{[]ScanComponent{{1, 0, 0}, {2, 0, 0}, {3, 0, 0}}, 0, 0, 0, 1}, "Y Cb Cr, DC, Al=1"},
+		{color, SOF2, ScanHeader{[]ScanComponent{{1, 0, 0}}, 1, 5, 2, 1}, "Y, AC 1-5, Ah=2, Al=1"},
+		{rgb, SOF3, ScanHeader{[]ScanComponent{{'R', 0, 0}, {'G', 0, 0}}, 1, 0, 0, 0}, "R G, predictor 1, Pt=0"},
+		{cmyk, SOF1, ScanHeader{[]ScanComponent{{1, 0, 0}, {4, 0, 0}}, 0, 63, 0, 0}, "1 4, DC and AC 1-63"},
+	}
+	for _, test := range tests {
+		if got := test.scan.Describe(test.frame, test.marker.Process()); got != test.describe {
+			t.Errorf("got %q, expected %q", got, test.describe)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package jpegsegs
+
+import (
+	"bytes"
+	tiff "github.com/garyhouston/tiff66"
+)
+
+// Support for the JPEG thumbnail in IFD1 of an Exif TIFF tree, as
+// returned by GetExifTree.
+
+// Tags in IFD1 that describe a JPEG thumbnail.
+const (
+	exifCompression                 = 0x0103
+	exifJPEGInterchangeFormat       = 0x0201
+	exifJPEGInterchangeFormatLength = 0x0202
+)
+
+// ExifThumbnail returns the JPEG thumbnail from IFD1 of an Exif TIFF
+// tree, or nil if there's none.
+func ExifThumbnail(tree *tiff.IFDNode) []byte {
+	if tree.Next == nil {
+		return nil
+	}
+	for _, data := range tree.Next.ImageData {
+		if data.OffsetTag == exifJPEGInterchangeFormat {
+			return bytes.Join(data.Segments, nil)
+		}
+	}
+	return nil
+}
+
+// SetExifThumbnail replaces the JPEG thumbnail in IFD1 of an Exif TIFF
+// tree, creating IFD1 if needed. The thumbnail's position is assigned
+// when the tree is encoded by MakeExifSegment. ErrThumbnailJPEG is
+// returned if the thumbnail doesn't start with an SOI marker. If the
+// tree would be too large for an APP1 segment, it's left unchanged and
+// ErrThumbnailTooLong is returned.
+func SetExifThumbnail(tree *tiff.IFDNode, thumb []byte) error {
+	if !IsJPEGHeader(thumb) {
+		return ErrThumbnailJPEG
+	}
+	var ifd1 tiff.IFDNode
+	if tree.Next != nil {
+		ifd1 = *tree.Next
+	} else {
+		ifd1.Order = tree.Order
+		ifd1.Space = tree.Space
+		// Compression 6 indicates a JPEG thumbnail.
+		compression := tiff.Field{Tag: exifCompression, Type: tiff.SHORT, Count: 1, Data: make([]byte, 2)}
+		compression.PutShort(6, 0, tree.Order)
+		ifd1.Fields = []tiff.Field{compression}
+	}
+	ifd1.Fields = append([]tiff.Field(nil), ifd1.Fields...)
+	setLongField(&ifd1, exifJPEGInterchangeFormat, 0)
+	setLongField(&ifd1, exifJPEGInterchangeFormatLength, uint32(len(thumb)))
+	var imageData []tiff.ImageData
+	for _, data := range ifd1.ImageData {
+		if data.OffsetTag != exifJPEGInterchangeFormat {
+			imageData = append(imageData, data)
+		}
+	}
+	ifd1.ImageData = append(imageData, tiff.ImageData{
+		OffsetTag: exifJPEGInterchangeFormat,
+		SizeTag:   exifJPEGInterchangeFormatLength,
+		Segments:  [][]byte{thumb}})
+	old := tree.Next
+	tree.Next = &ifd1
+	if ExifHeaderSize+tiff.HeaderSize+tree.TreeSize() > MaxDataSize {
+		tree.Next = old
+		return ErrThumbnailTooLong
+	}
+	return nil
+}
+
+// DropExifThumbnail removes IFD1, which holds the thumbnail, from an
+// Exif TIFF tree.
+func DropExifThumbnail(tree *tiff.IFDNode) {
+	tree.Next = nil
+}
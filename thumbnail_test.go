@@ -0,0 +1,73 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestExifThumbnail(t *testing.T) {
+	small := makeJPEG(t, 16, 8, true)
+	large := makeJPEG(t, 32, 16, false)
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		tree := exifTestTree(order)
+		if ExifThumbnail(tree) != nil {
+			t.Fatal("thumbnail found in a tree without IFD1")
+		}
+		// Setting a thumbnail twice replaces the first one.
+		for _, thumb := range [][]byte{small, large} {
+			if err := SetExifThumbnail(tree, thumb); err != nil {
+				t.Fatal(err)
+			}
+			seg, err := MakeExifSegment(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := GetExifTree(seg[ExifHeaderSize:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ExifThumbnail(decoded); !bytes.Equal(got, thumb) {
+				t.Errorf("got a thumbnail of %d bytes, expected %d", len(got), len(thumb))
+			}
+			if len(decoded.Next.ImageData) != 1 {
+				t.Errorf("%d image data items in IFD1", len(decoded.Next.ImageData))
+			}
+			if ExifOrientation(decoded) != 6 {
+				t.Error("IFD0 changed")
+			}
+		}
+		DropExifThumbnail(tree)
+		if tree.Next != nil || ExifThumbnail(tree) != nil {
+			t.Error("thumbnail not dropped")
+		}
+	}
+}
+
+func TestSetExifThumbnailErrors(t *testing.T) {
+	thumb := makeJPEG(t, 16, 8, true)
+	tooLong := append([]byte{0xFF, byte(SOI)}, make([]byte, MaxDataSize)...)
+	tests := []struct {
+		name  string
+		thumb []byte
+		err   error
+	}{
+		{"nil", nil, ErrThumbnailJPEG},
+		{"one byte", []byte{0xFF}, ErrThumbnailJPEG},
+		{"not JPEG", []byte{0x89, 'P', 'N', 'G'}, ErrThumbnailJPEG},
+		{"too long", tooLong, ErrThumbnailTooLong},
+	}
+	for _, test := range tests {
+		tree := exifTestTree(binary.BigEndian)
+		if err := SetExifThumbnail(tree, thumb); err != nil {
+			t.Fatal(err)
+		}
+		old := tree.Next
+		if err := SetExifThumbnail(tree, test.thumb); err != test.err {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+		if tree.Next != old || !bytes.Equal(ExifThumbnail(tree), thumb) {
+			t.Errorf("%s: tree was modified", test.name)
+		}
+	}
+}
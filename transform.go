@@ -0,0 +1,176 @@
+package jpegsegs
+
+// Lossless transformations of DCT coefficients.
+
+// Transform is a lossless rotation or mirroring of an image.
+type Transform int
+
+// Transforms, numbered so that Transform(n-1) corrects an image with
+// Exif Orientation n.
+const (
+	TransformNone  Transform = iota // No change.
+	FlipHorizontal                  // Mirror left to right.
+	Rotate180                       // Rotate by 180 degrees.
+	FlipVertical                    // Mirror top to bottom.
+	Transpose                       // Mirror along the top-left to bottom-right diagonal.
+	Rotate90                        // Rotate by 90 degrees clockwise.
+	Transverse                      // Mirror along the top-right to bottom-left diagonal.
+	Rotate270                       // Rotate by 270 degrees clockwise.
+)
+
+var transformNames = [...]string{
+	TransformNone:  "none",
+	FlipHorizontal: "fliph",
+	Rotate180:      "rotate180",
+	FlipVertical:   "flipv",
+	Transpose:      "transpose",
+	Rotate90:       "rotate90",
+	Transverse:     "transverse",
+	Rotate270:      "rotate270",
+}
+
+// String returns the name of a transform, as used by jpegsegsrotate.
+func (t Transform) String() string {
+	if t >= 0 && int(t) < len(transformNames) {
+		return transformNames[t]
+	}
+	return "unknown"
+}
+
+// OrientationTransform returns the transform that displays an image
+// with the given Exif Orientation value upright.
+func OrientationTransform(orientation uint16) Transform {
+	if orientation < 1 || orientation > 8 {
+		return TransformNone
+	}
+	return Transform(orientation - 1)
+}
+
+// steps decomposes a transform into an optional transposition
+// followed by optional horizontal and vertical mirroring.
+func (t Transform) steps() (transpose, mirrorX, mirrorY bool) {
+	switch t {
+	case FlipHorizontal:
+		return false, true, false
+	case Rotate180:
+		return false, true, true
+	case FlipVertical:
+		return false, false, true
+	case Transpose:
+		return true, false, false
+	case Rotate90:
+		return true, true, false
+	case Transverse:
+		return true, true, true
+	case Rotate270:
+		return true, false, true
+	}
+	return false, false, false
+}
+
+// transpose transposes a block of coefficients.
+func (block *Block) transpose() {
+	for i := 0; i < 8; i++ {
+		for j := i + 1; j < 8; j++ {
+			block[i*8+j], block[j*8+i] = block[j*8+i], block[i*8+j]
+		}
+	}
+}
+
+// mirror mirrors a block horizontally and/or vertically, by negating
+// the coefficients of odd horizontal or vertical frequencies.
+func (block *Block) mirror(x, y bool) {
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			negX := x && j&1 != 0
+			negY := y && i&1 != 0
+			if negX != negY {
+				block[i*8+j] = -block[i*8+j]
+			}
+		}
+	}
+}
+
+// transposeQuantTable transposes the values of a quantization table.
+func transposeQuantTable(table QuantTable) QuantTable {
+	natural := ZigZagToNatural(table.Values)
+	var transposed [64]uint16
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			transposed[j*8+i] = natural[i*8+j]
+		}
+	}
+	table.Values = NaturalToZigZag(transposed)
+	return table
+}
+
+// Transform returns a transformed copy of the coefficients. Mirroring
+// can only be done on whole iMCUs (8 pixels times the maximum sampling
+// factor), so if the image doesn't fill the iMCUs at the edges to be
+// mirrored, the partial blocks there are left untransformed, unless
+// 'trim' is set, in which case they are dropped. Sampling factors,
+// image dimensions and quantization tables are transposed as required.
+func (coeffs *Coefficients) Transform(t Transform, trim bool) *Coefficients {
+	transpose, mirrorX, mirrorY := t.steps()
+	frame := coeffs.Frame
+	frame.Components = append([]FrameComponent(nil), coeffs.Frame.Components...)
+	if transpose {
+		frame.Width, frame.Height = frame.Height, frame.Width
+		for i := range frame.Components {
+			comp := &frame.Components[i]
+			comp.H, comp.V = comp.V, comp.H
+		}
+	}
+	hmax, vmax := frame.MaxSampling()
+	if trim && mirrorX && int(frame.Width) >= 8*hmax {
+		frame.Width -= frame.Width % uint16(8*hmax)
+	}
+	if trim && mirrorY && int(frame.Height) >= 8*vmax {
+		frame.Height -= frame.Height % uint16(8*vmax)
+	}
+	out := NewCoefficients(coeffs.Marker, &frame)
+	for _, table := range coeffs.QuantTables {
+		if transpose {
+			table = transposeQuantTable(table)
+		}
+		out.QuantTables = append(out.QuantTables, table)
+	}
+	out.HuffmanTables = coeffs.HuffmanTables
+	out.RestartInterval = coeffs.RestartInterval
+	out.Scans = coeffs.Scans
+	// Number of whole iMCUs in each direction, in the output.
+	fullX := int(frame.Width) / (8 * hmax)
+	fullY := int(frame.Height) / (8 * vmax)
+	for i := range out.Components {
+		dst := &out.Components[i]
+		src := &coeffs.Components[i]
+		width := fullX * int(dst.H)
+		height := fullY * int(dst.V)
+		for y := 0; y < dst.Rows; y++ {
+			for x := 0; x < dst.Stride; x++ {
+				flipX := mirrorX && x < width
+				flipY := mirrorY && y < height
+				sx, sy := x, y
+				if flipX {
+					sx = width - 1 - x
+				}
+				if flipY {
+					sy = height - 1 - y
+				}
+				if transpose {
+					sx, sy = sy, sx
+				}
+				if sx >= src.Stride || sy >= src.Rows {
+					continue
+				}
+				block := dst.Block(x, y)
+				*block = *src.Block(sx, sy)
+				if transpose {
+					block.transpose()
+				}
+				block.mirror(flipX, flipY)
+			}
+		}
+	}
+	return out
+}
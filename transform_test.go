@@ -0,0 +1,129 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// inverse returns the transform that undoes a transform.
+func (t Transform) inverse() Transform {
+	switch t {
+	case Rotate90:
+		return Rotate270
+	case Rotate270:
+		return Rotate90
+	}
+	return t
+}
+
+// luma decodes an image and returns its luma samples and stride.
+func luma(t *testing.T, data []byte) ([]uint8, int, image.Rectangle) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return img.Y, img.YStride, img.Rect
+	case *image.Gray:
+		return img.Pix, img.Stride, img.Rect
+	}
+	t.Fatalf("unexpected image type %T", img)
+	return nil, 0, image.Rectangle{}
+}
+
+func TestTransformInverse(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		coeffs, _ := readCoefficients(t, makeJPEG(t, 64, 48, gray))
+		for tr := TransformNone; tr <= Rotate270; tr++ {
+			back := coeffs.Transform(tr, false).Transform(tr.inverse(), false)
+			if back.Frame.Width != coeffs.Frame.Width || back.Frame.Height != coeffs.Frame.Height {
+				t.Fatalf("%s: dimensions %dx%d after inverse", tr, back.Frame.Width, back.Frame.Height)
+			}
+			sameCoefficients(t, coeffs, back)
+			for i := range coeffs.QuantTables {
+				if back.QuantTables[i] != coeffs.QuantTables[i] {
+					t.Errorf("%s: quantization table %d differs after inverse", tr, i)
+				}
+			}
+		}
+	}
+}
+
+func TestTransformPixels(t *testing.T) {
+	tests := []struct {
+		width, height int
+		gray          bool
+		trim          bool
+	}{
+		{64, 48, false, false},
+		{64, 48, true, false},
+		{77, 53, false, true},
+		{77, 53, true, true},
+	}
+	for _, test := range tests {
+		data := makeJPEG(t, test.width, test.height, test.gray)
+		src, srcStride, _ := luma(t, data)
+		coeffs, segments := readCoefficients(t, data)
+		for tr := TransformNone; tr <= Rotate270; tr++ {
+			out := coeffs.Transform(tr, test.trim)
+			dst, dstStride, bounds := luma(t, writeCoefficients(t, out, segments, nil))
+			if bounds.Dx() != int(out.Frame.Width) || bounds.Dy() != int(out.Frame.Height) {
+				t.Fatalf("%s: decoded %v, frame %dx%d", tr, bounds, out.Frame.Width, out.Frame.Height)
+			}
+			transpose, mirrorX, mirrorY := tr.steps()
+			maxDiff := 0
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					sx, sy := x, y
+					if mirrorX {
+						sx = bounds.Dx() - 1 - sx
+					}
+					if mirrorY {
+						sy = bounds.Dy() - 1 - sy
+					}
+					if transpose {
+						sx, sy = sy, sx
+					}
+					diff := int(dst[y*dstStride+x]) - int(src[sy*srcStride+sx])
+					if diff < 0 {
+						diff = -diff
+					}
+					if diff > maxDiff {
+						maxDiff = diff
+					}
+				}
+			}
+			// Allow for rounding in the inverse DCT.
+			if maxDiff > 2 {
+				t.Errorf("%dx%d gray %v, %s: luma differs by up to %d", test.width, test.height, test.gray, tr, maxDiff)
+			}
+		}
+	}
+}
+
+func TestTransformScans(t *testing.T) {
+	coeffs, segments := readCoefficients(t, makeJPEG(t, 40, 24, false))
+	script := ProgressiveScript(&coeffs.Frame)
+	coeffs, _ = readCoefficients(t, writeCoefficients(t, coeffs, segments, &EncodeOptions{Progressive: true}))
+	if len(coeffs.Scans) != len(script) {
+		t.Fatalf("%d scans decoded, expected %d", len(coeffs.Scans), len(script))
+	}
+	script = coeffs.Scans
+	// The scan script can be reused after a transform or crop.
+	for tr := TransformNone; tr <= Rotate270; tr++ {
+		if out := coeffs.Transform(tr, false); !reflect.DeepEqual(out.Scans, script) {
+			t.Errorf("%s: scans not kept", tr)
+		}
+	}
+	out, _, err := coeffs.Crop(image.Rect(8, 8, 30, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Scans, script) {
+		t.Error("crop: scans not kept")
+	}
+}
@@ -0,0 +1,189 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Support for XMP packets in APP1 segments. A packet that's too large
+// for a single segment is split into a standard packet and Extended
+// XMP, which is stored in the chunks described in multiseg.go and
+// identified by the xmpNote:HasExtendedXMP property of the standard
+// packet.
+
+// XMPHeader is the text marker for a standard XMP packet, found in a
+// JPEG APP1 segment.
+var XMPHeader = []byte("http://ns.adobe.com/xap/1.0/\000")
+
+// XMPHeaderSize is the size of an XMP header.
+const XMPHeaderSize = 29
+
+// hasExtendedXMP is the name of the property giving the GUID of
+// Extended XMP, excluding the namespace prefix.
+var hasExtendedXMP = []byte("HasExtendedXMP")
+
+// isXMPSegment checks if a segment contains a standard XMP packet.
+func isXMPSegment(segment Segment) bool {
+	return segment.Marker == APP1 && bytes.HasPrefix(segment.Data, XMPHeader)
+}
+
+// isExtendedXMPSegment checks if a segment contains an Extended XMP
+// chunk.
+func isExtendedXMPSegment(segment Segment) bool {
+	return segment.Marker == APP1 && bytes.HasPrefix(segment.Data, ExtendedXMPHeader)
+}
+
+// GetXMP returns the standard XMP packet from the first XMP APP1
+// segment in a list of segments, or nil if there's none.
+func GetXMP(segments []Segment) []byte {
+	for _, segment := range segments {
+		if isXMPSegment(segment) {
+			return segment.Data[XMPHeaderSize:]
+		}
+	}
+	return nil
+}
+
+// extendedXMPGUIDPos returns the position of the GUID in the
+// xmpNote:HasExtendedXMP property of an XMP packet, written either as
+// an attribute or an element, or -1 if not found.
+func extendedXMPGUIDPos(packet []byte) int {
+	pos := bytes.Index(packet, hasExtendedXMP)
+	if pos < 0 {
+		return -1
+	}
+	pos += len(hasExtendedXMP)
+	skipSpace := func() {
+		for pos < len(packet) && bytes.IndexByte([]byte(" \t\r\n"), packet[pos]) >= 0 {
+			pos++
+		}
+	}
+	skipSpace()
+	switch {
+	case pos < len(packet) && packet[pos] == '=':
+		pos++
+		skipSpace()
+		if pos >= len(packet) || packet[pos] != '"' && packet[pos] != '\'' {
+			return -1
+		}
+		pos++
+	case pos < len(packet) && packet[pos] == '>':
+		pos++
+		skipSpace()
+	default:
+		return -1
+	}
+	if pos+32 > len(packet) {
+		return -1
+	}
+	if _, err := hex.DecodeString(string(packet[pos : pos+32])); err != nil {
+		return -1
+	}
+	return pos
+}
+
+// HasExtendedXMP returns the GUID of the Extended XMP belonging to a
+// standard XMP packet, from its xmpNote:HasExtendedXMP property, or an
+// empty string if there's none.
+func HasExtendedXMP(packet []byte) string {
+	pos := extendedXMPGUIDPos(packet)
+	if pos < 0 {
+		return ""
+	}
+	return string(packet[pos : pos+32])
+}
+
+// GetFullXMP returns the standard XMP packet from a list of segments,
+// as per GetXMP, and the Extended XMP identified by its
+// xmpNote:HasExtendedXMP property, if any. The Extended XMP is
+// reassembled as per GetExtendedXMP and its digest is checked against
+// the GUID. If the Extended XMP is missing or invalid, the standard
+// packet is still returned along with the error.
+func GetFullXMP(segments []Segment) ([]byte, []byte, error) {
+	packet := GetXMP(segments)
+	guid := HasExtendedXMP(packet)
+	if guid == "" {
+		return packet, nil, nil
+	}
+	extended, err := GetExtendedXMP(segments, guid)
+	if err != nil {
+		return packet, nil, err
+	}
+	if extended == nil {
+		return packet, nil, &SyntaxError{-1, APP1, ErrXMPChunks}
+	}
+	if !strings.EqualFold(ExtendedXMPGUID(extended), guid) {
+		return packet, nil, &SyntaxError{-1, APP1, ErrXMPDigest}
+	}
+	return packet, extended, nil
+}
+
+// setExtendedXMPGUID returns a copy of an XMP packet with its
+// xmpNote:HasExtendedXMP property set to 'guid'. If there's no such
+// property, it's added to the first rdf:Description element.
+func setExtendedXMPGUID(packet []byte, guid string) ([]byte, error) {
+	if pos := extendedXMPGUIDPos(packet); pos >= 0 {
+		result := append([]byte(nil), packet...)
+		copy(result[pos:], guid)
+		return result, nil
+	}
+	description := []byte("<rdf:Description")
+	pos := bytes.Index(packet, description)
+	if pos < 0 {
+		return nil, &SyntaxError{-1, APP1, ErrXMPPacket}
+	}
+	pos += len(description)
+	attrs := ` xmlns:xmpNote="http://ns.adobe.com/xmp/note/" xmpNote:HasExtendedXMP="` + guid + `"`
+	result := make([]byte, 0, len(packet)+len(attrs))
+	result = append(result, packet[:pos]...)
+	result = append(result, attrs...)
+	return append(result, packet[pos:]...), nil
+}
+
+// MakeXMPSegment adds an XMP header to a standard XMP packet, returning
+// a newly allocated slice which can be used as an APP1 segment.
+func MakeXMPSegment(packet []byte) ([]byte, error) {
+	if len(packet) > MaxDataSize-XMPHeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, XMPHeaderSize+len(packet))
+	}
+	buf := make([]byte, XMPHeaderSize+len(packet))
+	copy(buf, XMPHeader)
+	copy(buf[XMPHeaderSize:], packet)
+	return buf, nil
+}
+
+// SetXMP returns a copy of a list of segments with the XMP segments
+// replaced by new ones containing a standard XMP packet and, if
+// 'extended' isn't nil, Extended XMP. The Extended XMP is split into
+// chunks labelled with its GUID, as returned by ExtendedXMPGUID, and
+// the xmpNote:HasExtendedXMP property of the packet is set to match.
+// The new segments take the place of the first old XMP segment, or if
+// there was none, follow any APP0 and APP1 segments at the start of
+// the list. If 'packet' is nil, the XMP segments are removed.
+func SetXMP(segments []Segment, packet, extended []byte) ([]Segment, error) {
+	var replacement []Segment
+	if packet != nil {
+		var chunks []Segment
+		if extended != nil {
+			guid := ExtendedXMPGUID(extended)
+			var err error
+			if packet, err = setExtendedXMPGUID(packet, guid); err != nil {
+				return nil, err
+			}
+			if chunks, err = MakeExtendedXMPSegments(guid, extended); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := MakeXMPSegment(packet)
+		if err != nil {
+			return nil, err
+		}
+		replacement = append([]Segment{{APP1, buf}}, chunks...)
+	}
+	match := func(segment Segment) bool {
+		return isXMPSegment(segment) || isExtendedXMPSegment(segment)
+	}
+	return replaceSegments(segments, match, replacement), nil
+}
@@ -0,0 +1,112 @@
+package jpegsegs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// xmpPacket is a minimal standard XMP packet.
+var xmpPacket = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description rdf:about=""/></rdf:RDF></x:xmpmeta>`)
+
+func TestXMPRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte("<rdf:Description/>"), 10000)
+	tests := []struct {
+		name     string
+		extended []byte
+	}{
+		{"standard only", nil},
+		{"one chunk", []byte("<x:xmpmeta/>")},
+		{"several chunks", large},
+	}
+	for _, test := range tests {
+		segments := []Segment{{APP0, []byte("JFIF\000")}, {DQT, nil}}
+		segments, err := SetXMP(segments, xmpPacket, test.extended)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !isXMPSegment(segments[1]) {
+			t.Errorf("%s: XMP segment not after APP0", test.name)
+		}
+		packet, extended, err := GetFullXMP(segments)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.extended == nil {
+			if !bytes.Equal(packet, xmpPacket) || extended != nil {
+				t.Errorf("%s: packet %q, extended %q", test.name, packet, extended)
+			}
+			continue
+		}
+		if HasExtendedXMP(packet) != ExtendedXMPGUID(test.extended) {
+			t.Errorf("%s: GUID %q, expected %q", test.name, HasExtendedXMP(packet), ExtendedXMPGUID(test.extended))
+		}
+		if !bytes.Equal(extended, test.extended) {
+			t.Errorf("%s: Extended XMP differs", test.name)
+		}
+		// Replacing the XMP removes the old chunks.
+		segments, err = SetXMP(segments, xmpPacket, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) != 3 {
+			t.Errorf("%s: %d segments after removing Extended XMP", test.name, len(segments))
+		}
+	}
+}
+
+func TestGetFullXMPErrors(t *testing.T) {
+	extended := []byte("<x:xmpmeta/>")
+	segments, err := SetXMP(nil, xmpPacket, extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func([]Segment) []Segment
+		err    error
+	}{
+		{"lower-case GUID in chunks", func(s []Segment) []Segment {
+			guid := ExtendedXMPGUID(extended)
+			for i := 1; i < len(s); i++ {
+				s[i].Data = bytes.Replace(s[i].Data, []byte(guid), []byte(strings.ToLower(guid)), 1)
+			}
+			return s
+		}, nil},
+		{"lower-case GUID in packet", func(s []Segment) []Segment {
+			guid := ExtendedXMPGUID(extended)
+			s[0].Data = bytes.Replace(s[0].Data, []byte(guid), []byte(strings.ToLower(guid)), 1)
+			return s
+		}, nil},
+		{"missing chunk", func(s []Segment) []Segment {
+			return s[:1]
+		}, ErrXMPChunks},
+		{"digest mismatch", func(s []Segment) []Segment {
+			s[1].Data[len(s[1].Data)-2] = 'X'
+			return s
+		}, ErrXMPDigest},
+		{"truncated chunk", func(s []Segment) []Segment {
+			s[1].Data = s[1].Data[:len(s[1].Data)-1]
+			return s
+		}, ErrXMPChunks},
+	}
+	for _, test := range tests {
+		modified := make([]Segment, len(segments))
+		for i, segment := range segments {
+			modified[i] = Segment{segment.Marker, append([]byte(nil), segment.Data...)}
+		}
+		modified = test.modify(modified)
+		packet, got, err := GetFullXMP(modified)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+		// The standard packet is returned even with an error.
+		if HasExtendedXMP(packet) == "" {
+			t.Errorf("%s: no standard packet", test.name)
+		}
+		if err == nil && !bytes.Equal(got, extended) {
+			t.Errorf("%s: Extended XMP %q", test.name, got)
+		}
+	}
+}